@@ -2,7 +2,11 @@ package apireg
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -16,12 +20,26 @@ type Api interface {
 	UUID() uuid.UUID
 	//IP address that the application is being served on. Used for the client to dial back
 	HostIP() net.IP
+	//Zone (interface name) for IPv6 link-local HostIPs, ex "eth0" for fe80::1%eth0. Empty for everything else
+	HostZone() string
 	//Port that the client should dial the serving application on.
 	HostPort() int
+	//Hostname the serving application advertised for itself. Empty if it didn't give one
+	Hostname() string
+	//Metadata is free form key/value info about the api, ex scheme=https or path=/v1. Returns a copy so it is safe to change
+	Metadata() map[string]string
+	//MetadataValue looks up a single metadata key
+	MetadataValue(key string) (string, bool)
 	//Equal is used to determine if the two apis are the same
 	Equal(Api) bool
 	//Environment that the server hosting this api is running in Prod, Non-Prod or ALL
 	Environment() Environment
+	//Protocol the api speaks, ex HTTP or GRPC. UnknownProtocol if it wasn't given
+	Protocol() Protocol
+	//Address is host:port ready to be dialed, IPv6 safe and including the zone if there is one
+	Address() string
+	//URL builds protocol://address/path. Returns an error if the protocol is unknown
+	URL(path string) (string, error)
 }
 
 type apiImpl struct {
@@ -29,11 +47,69 @@ type apiImpl struct {
 	version    Version
 	uuid       uuid.UUID
 	remoteIP   net.IP
+	remoteZone string
 	remotePort int
+	hostname   string
+	metadata   map[string]string
+	protocol   Protocol
 	env        Environment
 }
 
-func NewApi(name string, ver Version, uuid uuid.UUID, env Environment, hostIP net.IP, port int) (Api, error) {
+// ApiOption sets one of the optional fields of an Api. Pass any number of them to NewApi
+type ApiOption func(*apiImpl) error
+
+// WithHostZone sets the IPv6 zone that HostIP needs to be dialed with
+func WithHostZone(zone string) ApiOption {
+	return func(a *apiImpl) error {
+		a.remoteZone = zone
+		return nil
+	}
+}
+
+// WithHostIP replaces the hostIP given to NewApi. Used with RegisterApi to advertise an address other than the one our packets come from, ex behind NAT
+func WithHostIP(ip net.IP) ApiOption {
+	return func(a *apiImpl) error {
+		if ip == nil {
+			return errors.New("ip is required for WithHostIP")
+		}
+		a.remoteIP = ip
+		return nil
+	}
+}
+
+// WithHostname sets the hostname that clients can use instead of HostIP
+func WithHostname(hostname string) ApiOption {
+	return func(a *apiImpl) error {
+		a.hostname = hostname
+		return nil
+	}
+}
+
+// WithMetadata adds key/value metadata to the api. The map is copied so later changes to it don't leak in
+func WithMetadata(metadata map[string]string) ApiOption {
+	return func(a *apiImpl) error {
+		for key, value := range metadata {
+			if key == "" {
+				return errors.New("metadata keys can not be empty")
+			}
+			if a.metadata == nil {
+				a.metadata = make(map[string]string, len(metadata))
+			}
+			a.metadata[key] = value
+		}
+		return nil
+	}
+}
+
+// WithProtocol sets the protocol clients should use to talk to the api
+func WithProtocol(p Protocol) ApiOption {
+	return func(a *apiImpl) error {
+		a.protocol = p
+		return nil
+	}
+}
+
+func NewApi(name string, ver Version, uuid uuid.UUID, env Environment, hostIP net.IP, port int, opts ...ApiOption) (Api, error) {
 	if name == "" {
 		return nil, errors.New("name is required for NewApi")
 	} else if ver == nil {
@@ -44,7 +120,13 @@ func NewApi(name string, ver Version, uuid uuid.UUID, env Environment, hostIP ne
 		return nil, errors.New("port must be > 0 for NewApi")
 	}
 
-	return &apiImpl{name: name, version: ver, uuid: uuid, env: env, remoteIP: hostIP, remotePort: port}, nil
+	a := &apiImpl{name: name, version: ver, uuid: uuid, env: env, remoteIP: hostIP, remotePort: port}
+	for _, curOpt := range opts {
+		if err := curOpt(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
 }
 
 func (this *apiImpl) Name() string {
@@ -61,16 +143,51 @@ func (this *apiImpl) UUID() uuid.UUID {
 func (this *apiImpl) HostIP() net.IP {
 	return this.remoteIP
 }
+func (this *apiImpl) HostZone() string {
+	return this.remoteZone
+}
 func (this *apiImpl) HostPort() int {
 	return this.remotePort
 }
+func (this *apiImpl) Hostname() string {
+	return this.hostname
+}
+func (this *apiImpl) Metadata() map[string]string {
+	metadataCopy := make(map[string]string, len(this.metadata))
+	for key, value := range this.metadata {
+		metadataCopy[key] = value
+	}
+	return metadataCopy
+}
+
+func (this *apiImpl) MetadataValue(key string) (string, bool) {
+	value, contains := this.metadata[key]
+	return value, contains
+}
+
 func (this *apiImpl) Equal(other Api) bool {
 	return other != nil &&
 		this.name == other.Name() &&
 		this.version.Equal(other.Version()) &&
 		this.remoteIP.Equal(other.HostIP()) &&
+		this.remoteZone == other.HostZone() &&
 		this.uuidEqual(other) &&
-		this.remotePort == other.HostPort()
+		this.remotePort == other.HostPort() &&
+		this.protocol == other.Protocol() &&
+		this.metadataEqual(other)
+}
+
+func (this *apiImpl) metadataEqual(other Api) bool {
+	otherMetadata := other.Metadata()
+	if len(this.metadata) != len(otherMetadata) {
+		return false
+	}
+	for key, value := range this.metadata {
+		if otherValue, contains := otherMetadata[key]; !contains || otherValue != value {
+			return false
+		}
+	}
+	return true
 }
 
 func (this *apiImpl) uuidEqual(other Api) bool {
@@ -90,3 +207,27 @@ func (this *apiImpl) uuidEqual(other Api) bool {
 func (this *apiImpl) Environment() Environment {
 	return this.env
 }
+
+func (this *apiImpl) Protocol() Protocol {
+	return this.protocol
+}
+
+func (this *apiImpl) Address() string {
+	host := this.remoteIP.String()
+	if this.remoteZone != "" {
+		host += "%" + this.remoteZone
+	}
+	return net.JoinHostPort(host, strconv.Itoa(this.remotePort))
+}
+
+func (this *apiImpl) URL(path string) (string, error) {
+	if this.protocol == UnknownProtocol {
+		return "", errors.New(fmt.Sprint("api ", this.name, " did not register a protocol so a URL can't be made"))
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	//Letting url.URL do the work so IPv6 zones get escaped properly
+	u := &url.URL{Scheme: string(this.protocol), Host: this.Address(), Path: path}
+	return u.String(), nil
+}
@@ -1,9 +1,37 @@
 package apireg
 
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
 type ApiRegistry interface {
-	RegisterApi(name string, version Version, port int) error
+	//RegisterApi publishes one of our apis. opts can be used to set optional fields, ex WithHostIP to advertise a specific address
+	RegisterApi(name string, version Version, port int, opts ...ApiOption) error
+	//UnregisterApi stops publishing an api that was registered with RegisterApi and lets peers know it is gone
+	UnregisterApi(name string, version Version, port int) error
 	GetAvailableApis() []Api
 	GetApisByApiName(name string) []Api
+	//GetApisMatching returns apis for name whose version meets constraint, ex "^1.3" or ">=1.2.0 <2.0.0". See Constraint for the syntax
+	GetApisMatching(name string, constraint string) ([]Api, error)
+	//Find returns every api matching the query, ordered as SortApis does
+	Find(Query) []Api
+	//GetApisByMetadata returns all apis that have metadata key set to value
+	GetApisByMetadata(key, value string) []Api
+	//GetApisByInstance returns every api published by the registry with the given instance UUID
+	GetApisByInstance(id uuid.UUID) []Api
+	//Instances groups every known api by the registry instance that published it
+	Instances() []Instance
 	AddEventListener(RegistrationListener)
 	RemoveEventListener(RegistrationListener)
+	//Watch sends events for apis matching the query until ctx is done or the registry is closed, then the channel is closed.
+	//An update that moves an api into the query comes as Added and one that moves it out as Removed.
+	//If the channel isn't read fast enough the oldest unread events are dropped instead of blocking, the newest event says how many with Missed
+	Watch(ctx context.Context, q Query) (<-chan RegistrationEvent, error)
+	//Subscribe is Watch but first returns a snapshot of the apis matching the query. The channel has every event after the
+	//snapshot's revision and nothing before it, so nothing is missed or counted twice between the two
+	Subscribe(ctx context.Context, q Query) (Snapshot, <-chan RegistrationEvent, error)
+	//Close stops the registry and lets peers know that our apis are no longer available
+	Close() error
 }
@@ -0,0 +1,87 @@
+package apireg
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestThatNewApiWithEmptyMetadataKeyReturnsError(t *testing.T) {
+	_, err := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"": "oops"}))
+
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatChangingMetadataReturnedFromApiDoesNotChangeApi(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"scheme": "https"}))
+	a.Metadata()["scheme"] = "http"
+
+	if value, _ := a.MetadataValue("scheme"); value != "https" {
+		t.Fail()
+	}
+}
+
+func TestThatApisWithDifferentMetadataAreNotEqual(t *testing.T) {
+	id := uuid.New()
+	a0, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"zone": "shed-1"}))
+	a1, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"zone": "shed-2"}))
+
+	if a0.Equal(a1) {
+		t.Fail()
+	}
+}
+
+func TestThatApisWithSameMetadataAreEqual(t *testing.T) {
+	id := uuid.New()
+	a0, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"zone": "shed-1"}))
+	a1, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80, WithMetadata(map[string]string{"zone": "shed-1"}))
+
+	if !a0.Equal(a1) {
+		t.Fail()
+	}
+}
+
+func TestThatAddressJoinsIPv4HostAndPort(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 8080)
+
+	if a.Address() != "192.168.0.3:8080" {
+		t.Fail()
+	}
+}
+
+func TestThatAddressBracketsIPv6AndKeepsZone(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("fe80::1"), 8080, WithHostZone("eth0"))
+
+	if a.Address() != "[fe80::1%eth0]:8080" {
+		t.Fail()
+	}
+}
+
+func TestThatURLReturnsErrorWithoutProtocol(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 8080)
+
+	if _, err := a.URL("/v1"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatURLBuildsFullURL(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 8443, WithProtocol(HTTPS))
+
+	u, err := a.URL("v1/turnouts")
+	if err != nil || u != "https://192.168.0.3:8443/v1/turnouts" {
+		t.Fail()
+	}
+}
+
+func TestThatURLEscapesIPv6Zone(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("fe80::1"), 80, WithHostZone("eth0"), WithProtocol(HTTP))
+
+	u, _ := a.URL("")
+	if u != "http://[fe80::1%25eth0]:80" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,284 @@
+package apireg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Constraint is a set of version requirements that a Version either meets or doesn't
+//
+// Syntax:
+//
+//	">=1.2.0 <2.0.0"  space separated comparators must all match
+//	"^1.4"            same major, at least 1.4.0. For 0.x the minor can't change either
+//	"~1.4.2"          same major and minor, at least 1.4.2
+//	"1.4" or "1.4.x"  any 1.4 bugfix. "=1.4.2" or "1.4.2" for exactly that version
+//	"^1.4 || ^2.1"    either side can match
+//	"*"               anything
+//
+// Operators are =, >, >=, <, <=, ^ and ~, a space between one and its version is fine. Missing parts of a version act as wildcards.
+// Pre-release versions only match when a comparator names a pre-release of the same major.minor.bugfix
+type Constraint interface {
+	Check(Version) bool
+	String() string
+}
+
+type comparatorOp string
+
+const (
+	opEq  comparatorOp = "="
+	opGt  comparatorOp = ">"
+	opGte comparatorOp = ">="
+	opLt  comparatorOp = "<"
+	opLte comparatorOp = "<="
+)
+
+// Longest first so >= isn't read as >
+var comparatorOps = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+type comparator struct {
+	op      comparatorOp
+	version Version
+}
+
+func (this *comparator) check(v Version) bool {
+	c := v.Compare(this.version)
+	switch this.op {
+	case opEq:
+		return c == 0
+	case opGt:
+		return c > 0
+	case opGte:
+		return c >= 0
+	case opLt:
+		return c < 0
+	case opLte:
+		return c <= 0
+	}
+	return false
+}
+
+type constraintImpl struct {
+	raw string
+	//Any of the sets can match, all comparators in a set must match
+	sets [][]*comparator
+}
+
+// ParseConstraint reads a constraint string, see Constraint for the syntax
+func ParseConstraint(s string) (Constraint, error) {
+	c := &constraintImpl{raw: strings.TrimSpace(s)}
+
+	for _, curSetStr := range strings.Split(c.raw, "||") {
+		set := make([]*comparator, 0)
+		fields := joinOperators(strings.Fields(curSetStr))
+		if len(fields) == 0 {
+			//An empty constraint means anything which is the same as *, but an empty side of || is a mistake
+			if c.raw != "" {
+				return nil, errors.New(fmt.Sprint("constraint ", s, ": empty side of ||"))
+			}
+			fields = []string{"*"}
+		}
+		for _, curField := range fields {
+			comps, err := parseComparator(curField)
+			if err != nil {
+				return nil, errors.New(fmt.Sprint("constraint ", s, ": ", err))
+			}
+			set = append(set, comps...)
+		}
+		c.sets = append(c.sets, set)
+	}
+	return c, nil
+}
+
+// joinOperators puts operators that were written apart from their version back together, ex ">= 1.2.0"
+func joinOperators(fields []string) []string {
+	joined := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		if isComparatorOp(fields[i]) && i+1 < len(fields) {
+			joined = append(joined, fields[i]+fields[i+1])
+			i++
+		} else {
+			joined = append(joined, fields[i])
+		}
+	}
+	return joined
+}
+
+func isComparatorOp(s string) bool {
+	for _, curOp := range comparatorOps {
+		if s == curOp {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *constraintImpl) Check(v Version) bool {
+	if v == nil {
+		return false
+	}
+	for _, curSet := range this.sets {
+		if setMatches(curSet, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setMatches(set []*comparator, v Version) bool {
+	for _, curComp := range set {
+		if !curComp.check(v) {
+			return false
+		}
+	}
+	if v.PreRelease() == "" {
+		return true
+	}
+	//Only let pre-releases in if someone in the set asked for a pre-release of this exact release
+	for _, curComp := range set {
+		if curComp.version.PreRelease() != "" &&
+			curComp.version.Major() == v.Major() &&
+			curComp.version.Minor() == v.Minor() &&
+			curComp.version.BugFix() == v.BugFix() {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *constraintImpl) String() string {
+	return this.raw
+}
+
+// partialVersion is a version where trailing parts can be missing or wildcards, ex 1.4 or 1.x
+type partialVersion struct {
+	nums []uint
+	//Only allowed when all three numbers are given
+	preRelease string
+}
+
+func parsePartialVersion(s string) (*partialVersion, error) {
+	p := &partialVersion{}
+	remaining := strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(remaining, "-+"); i >= 0 {
+		//Hand anything with pre-release or build to the real parser which needs all three parts
+		full, err := ParseVersion(remaining)
+		if err != nil {
+			return nil, err
+		}
+		p.nums = []uint{full.Major(), full.Minor(), full.BugFix()}
+		p.preRelease = full.PreRelease()
+		return p, nil
+	}
+
+	for i, curPart := range strings.Split(remaining, ".") {
+		if i >= 3 {
+			return nil, errors.New(fmt.Sprint("version ", s, " has too many parts"))
+		}
+		if curPart == "x" || curPart == "X" || curPart == "*" {
+			//Everything after a wildcard is a wildcard too
+			break
+		}
+		num, err := parseVersionNumber(curPart)
+		if err != nil {
+			return nil, err
+		}
+		p.nums = append(p.nums, num)
+	}
+	return p, nil
+}
+
+func (this *partialVersion) part(i int) uint {
+	if i < len(this.nums) {
+		return this.nums[i]
+	}
+	return 0
+}
+
+func (this *partialVersion) floor() Version {
+	if this.preRelease != "" {
+		v, _ := NewSemanticVersion(this.part(0), this.part(1), this.part(2), this.preRelease, "")
+		return v
+	}
+	return NewVersion(this.part(0), this.part(1), this.part(2))
+}
+
+// nextAt is the lowest version after everything matching the first n parts, ex nextAt(2) of 1.4 is 1.5.0
+func (this *partialVersion) nextAt(n int) Version {
+	switch n {
+	case 1:
+		return NewVersion(this.part(0)+1, 0, 0)
+	case 2:
+		return NewVersion(this.part(0), this.part(1)+1, 0)
+	}
+	return NewVersion(this.part(0), this.part(1), this.part(2)+1)
+}
+
+func parseComparator(s string) ([]*comparator, error) {
+	var op string
+	for _, curOp := range comparatorOps {
+		if strings.HasPrefix(s, curOp) {
+			op = curOp
+			break
+		}
+	}
+	versionStr := strings.TrimSpace(s[len(op):])
+	if versionStr == "" {
+		return nil, errors.New(fmt.Sprint("comparator ", s, " needs a version"))
+	}
+	if versionStr == "*" || versionStr == "x" || versionStr == "X" {
+		if op == "" || op == "=" || op == ">=" || op == "<=" {
+			//Matches every release
+			return []*comparator{{op: opGte, version: NewVersion(0, 0, 0)}}, nil
+		}
+		return nil, errors.New(fmt.Sprint("comparator ", s, " needs a version"))
+	}
+
+	p, err := parsePartialVersion(versionStr)
+	if err != nil {
+		return nil, err
+	}
+	given := len(p.nums)
+	if given == 0 {
+		return []*comparator{{op: opGte, version: NewVersion(0, 0, 0)}}, nil
+	}
+
+	switch op {
+	case "", "=":
+		if given == 3 {
+			return []*comparator{{op: opEq, version: p.floor()}}, nil
+		}
+		return []*comparator{{op: opGte, version: p.floor()}, {op: opLt, version: p.nextAt(given)}}, nil
+	case ">":
+		if given == 3 {
+			return []*comparator{{op: opGt, version: p.floor()}}, nil
+		}
+		return []*comparator{{op: opGte, version: p.nextAt(given)}}, nil
+	case ">=":
+		return []*comparator{{op: opGte, version: p.floor()}}, nil
+	case "<":
+		return []*comparator{{op: opLt, version: p.floor()}}, nil
+	case "<=":
+		if given == 3 {
+			return []*comparator{{op: opLte, version: p.floor()}}, nil
+		}
+		return []*comparator{{op: opLt, version: p.nextAt(given)}}, nil
+	case "~":
+		//Bugfix changes are fine, or minor changes too if only the major was given
+		if given == 1 {
+			return []*comparator{{op: opGte, version: p.floor()}, {op: opLt, version: p.nextAt(1)}}, nil
+		}
+		return []*comparator{{op: opGte, version: p.floor()}, {op: opLt, version: p.nextAt(2)}}, nil
+	case "^":
+		//Anything that doesn't change the left most non zero part
+		upperAt := given
+		if p.part(0) != 0 || given == 1 {
+			upperAt = 1
+		} else if p.part(1) != 0 || given == 2 {
+			upperAt = 2
+		}
+		return []*comparator{{op: opGte, version: p.floor()}, {op: opLt, version: p.nextAt(upperAt)}}, nil
+	}
+	return nil, errors.New(fmt.Sprint("unknown comparator ", s))
+}
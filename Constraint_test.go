@@ -0,0 +1,75 @@
+package apireg
+
+import "testing"
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{">=1.2.0 <2.0.0", "v1.2.0", true},
+		{">=1.2.0 <2.0.0", "v1.9.9", true},
+		{">=1.2.0 <2.0.0", "v2.0.0", false},
+		{">=1.2.0 <2.0.0", "v1.1.9", false},
+		{"^1.4", "v1.4.0", true},
+		{"^1.4", "v1.99.0", true},
+		{"^1.4", "v1.3.9", false},
+		{"^1.4", "v2.0.0", false},
+		{"^0.4.1", "v0.4.5", true},
+		{"^0.4.1", "v0.5.0", false},
+		{"^0.0.3", "v0.0.3", true},
+		{"^0.0.3", "v0.0.4", false},
+		{"~1.4.2", "v1.4.9", true},
+		{"~1.4.2", "v1.4.1", false},
+		{"~1.4.2", "v1.5.0", false},
+		{"~1", "v1.9.0", true},
+		{"~1", "v2.0.0", false},
+		{"1.4", "v1.4.7", true},
+		{"1.4.x", "v1.5.0", false},
+		{"=1.4.2", "v1.4.2", true},
+		{"1.4.2", "v1.4.3", false},
+		{">1.4", "v1.4.9", false},
+		{">1.4", "v1.5.0", true},
+		{"<=1.4", "v1.4.9", true},
+		{"<=1.4", "v1.5.0", false},
+		{"^1.3 || ^3.0", "v3.1.0", true},
+		{"^1.3 || ^3.0", "v2.1.0", false},
+		{"*", "v7.0.0", true},
+		{">= 1.2.0 < 2.0.0", "v1.9.9", true},
+		{">= 1.2.0 < 2.0.0", "v2.0.0", false},
+		{"^ 1.4 || ~ 3.0", "v3.0.5", true},
+		{">=*", "v0.0.1", true},
+		{"", "v0.0.1", true},
+		//Pre-releases only match when asked for on the same release
+		{"^1.4", "v1.5.0-rc.1", false},
+		{">=1.5.0-rc.1", "v1.5.0-rc.2", true},
+		{">=1.5.0-rc.1", "v1.6.0-rc.1", false},
+		{"<2.0.0", "v2.0.0-rc.1", false},
+		//Build metadata doesn't change anything
+		{"=1.4.2", "v1.4.2+abc", true},
+	}
+
+	for _, curTest := range tests {
+		c, err := ParseConstraint(curTest.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) returned error %v", curTest.constraint, err)
+			continue
+		}
+		v, err := ParseVersion(curTest.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Check(v); got != curTest.want {
+			t.Errorf("%q.Check(%s) = %t, want %t", curTest.constraint, curTest.version, got, curTest.want)
+		}
+	}
+}
+
+func TestThatInvalidConstraintsReturnError(t *testing.T) {
+	for _, curConstraint := range []string{">=1.a", "^", "<", ">=", "<=", "=", ">= ", "^1.2 >=", "1.2.3.4", "~1.2.3-", ">>1.2", "^1.4 ||", "|| ^1.4", "^1.4 || || ^2.0", "||"} {
+		if _, err := ParseConstraint(curConstraint); err == nil {
+			t.Errorf("ParseConstraint(%q) expected an error", curConstraint)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package apireg
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Instance is one running registry, usually one service process, and every api it publishes
+type Instance struct {
+	//UUID the registry was started with, same as UUID() on each of its apis
+	ID uuid.UUID
+	//What the instance told us about itself, zero if it is too old to send it
+	InstanceInfo
+	Apis []Api
+}
+
+// InstanceInfo describes the process behind a registry so you can tell which box and which build an api is coming from
+type InstanceInfo struct {
+	//Hostname of the machine the process runs on, as the OS reports it
+	Hostname string
+	//When the process started
+	StartTime time.Time
+	PID       int
+	//Go runtime the process was built with, ex go1.22.6
+	GoVersion string
+	//Version of the main module, from the build info unless the instance set its own
+	BuildVersion string
+	//Free form key/value info about the instance, ex rack=shed-2 or board=rev-c
+	Labels map[string]string
+	//Goes up every time the instance restarts so peers can tell the new one from leftovers of the old one. 0 if it didn't say
+	Incarnation uint64
+}
+
+// LabelValue looks up a single label
+func (this InstanceInfo) LabelValue(key string) (string, bool) {
+	value, contains := this.Labels[key]
+	return value, contains
+}
+
+// GroupByInstance splits apis up by the instance that published them. Instances are ordered by ID and their apis as SortApis does
+func GroupByInstance(apis []Api) []Instance {
+	byID := make(map[uuid.UUID][]Api)
+	for _, curApi := range apis {
+		byID[curApi.UUID()] = append(byID[curApi.UUID()], curApi)
+	}
+
+	instances := make([]Instance, 0, len(byID))
+	for curID, curApis := range byID {
+		SortApis(curApis)
+		instances = append(instances, Instance{ID: curID, Apis: curApis})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return bytes.Compare(instances[i].ID[:], instances[j].ID[:]) < 0
+	})
+	return instances
+}
@@ -0,0 +1,119 @@
+package apireg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const instanceIDFileMode os.FileMode = 0600
+
+// instanceIDNamespace keeps IDs from MachineInstanceID from lining up with name based UUIDs anyone else makes
+var instanceIDNamespace = uuid.MustParse("cd54e38c-cc21-47b7-8fb3-9a3fcec07999")
+
+// Where the machine ID lives, systemd first then dbus for older distros
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// LoadOrCreateInstanceID returns the instance ID saved at path, or makes a new one and saves it there if the file
+// doesn't exist yet. Passing it to NewMulticastRegistry keeps the same ID across restarts so peers see a restart
+// instead of a new instance. A new file is only readable and writable by its owner, one that other users can write
+// to or that doesn't hold a valid ID is an error rather than being replaced
+func LoadOrCreateInstanceID(path string) (uuid.UUID, error) {
+	id, err := loadInstanceID(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return id, err
+	}
+
+	id = uuid.New()
+	if err = writeInstanceID(path, id); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func loadInstanceID(path string) (uuid.UUID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return uuid.Nil, errors.New(fmt.Sprint("instance ID file ", path, " is not a regular file"))
+	}
+	//Windows doesn't have unix permission bits to check
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0022 != 0 {
+		return uuid.Nil, errors.New(fmt.Sprint("instance ID file ", path, " can be written by other users, mode is ", info.Mode().Perm()))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(strings.TrimSpace(string(data)))
+	if err != nil || id == uuid.Nil {
+		return uuid.Nil, errors.New(fmt.Sprint("instance ID file ", path, " does not hold a valid instance ID"))
+	}
+	return id, nil
+}
+
+// writeInstanceID writes to a temp file next to path and renames it over so a crash never leaves half an ID behind
+func writeInstanceID(path string, id uuid.UUID) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	//Does nothing once the rename has happened
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(instanceIDFileMode); err != nil && runtime.GOOS != "windows" {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.WriteString(id.String() + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MachineInstanceID derives an instance ID from the machine ID and serviceName. It is the same every time the service
+// starts on the same machine without keeping any state of its own, and different for each service on it. Only works
+// where there is a machine ID, ex linux with systemd or dbus
+func MachineInstanceID(serviceName string) (uuid.UUID, error) {
+	if serviceName == "" {
+		return uuid.Nil, errors.New("service name is required for MachineInstanceID")
+	}
+	machineID, err := readMachineID()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.NewSHA1(instanceIDNamespace, []byte(machineID+"/"+serviceName)), nil
+}
+
+func readMachineID() (string, error) {
+	for _, curPath := range machineIDPaths {
+		data, err := os.ReadFile(curPath)
+		if err != nil {
+			continue
+		}
+		if machineID := strings.TrimSpace(string(data)); machineID != "" {
+			return machineID, nil
+		}
+	}
+	return "", errors.New(fmt.Sprint("no machine ID found in ", strings.Join(machineIDPaths, " or ")))
+}
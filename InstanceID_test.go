@@ -0,0 +1,104 @@
+package apireg
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestThatLoadOrCreateInstanceIDKeepsTheSameID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "instance-id")
+
+	id0, err := LoadOrCreateInstanceID(path)
+	if err != nil || id0 == uuid.Nil {
+		t.Fatal("expected a new ID", err)
+	}
+	id1, err := LoadOrCreateInstanceID(path)
+	if err != nil || id1 != id0 {
+		t.Fail()
+	}
+}
+
+func TestThatLoadOrCreateInstanceIDLeavesOnlyTheFileOwnerCanRead(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions on windows")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "instance-id")
+	LoadOrCreateInstanceID(path)
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fail()
+	}
+	//Temp file was renamed over so nothing else is left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fail()
+	}
+}
+
+func TestThatLoadOrCreateInstanceIDRejectsAFileOthersCanWrite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions on windows")
+	}
+	path := filepath.Join(t.TempDir(), "instance-id")
+	os.WriteFile(path, []byte(uuid.NewString()), 0600)
+	os.Chmod(path, 0666)
+
+	if _, err := LoadOrCreateInstanceID(path); err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatLoadOrCreateInstanceIDRejectsABadFileInsteadOfReplacingIt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance-id")
+	os.WriteFile(path, []byte("not a uuid"), 0600)
+
+	if _, err := LoadOrCreateInstanceID(path); err == nil {
+		t.Fail()
+	}
+	if data, _ := os.ReadFile(path); string(data) != "not a uuid" {
+		t.Fail()
+	}
+}
+
+func TestThatLoadOrCreateInstanceIDRejectsADirectory(t *testing.T) {
+	if _, err := LoadOrCreateInstanceID(t.TempDir()); err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatMachineInstanceIDIsStablePerService(t *testing.T) {
+	machineIDPath := filepath.Join(t.TempDir(), "machine-id")
+	os.WriteFile(machineIDPath, []byte("fed6b0e1c2d34a5f8e9d0c1b2a3f4e5d\n"), 0444)
+	oldPaths := machineIDPaths
+	machineIDPaths = []string{filepath.Join(t.TempDir(), "missing"), machineIDPath}
+	defer func() { machineIDPaths = oldPaths }()
+
+	smds0, err := MachineInstanceID("smds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smds1, _ := MachineInstanceID("smds")
+	tcc, _ := MachineInstanceID("tcc")
+	if smds0 != smds1 || smds0 == tcc || smds0 == uuid.Nil {
+		t.Fail()
+	}
+}
+
+func TestThatMachineInstanceIDNeedsAMachineIDAndServiceName(t *testing.T) {
+	oldPaths := machineIDPaths
+	machineIDPaths = []string{filepath.Join(t.TempDir(), "missing")}
+	defer func() { machineIDPaths = oldPaths }()
+
+	if _, err := MachineInstanceID("smds"); err == nil {
+		t.Fail()
+	}
+	if _, err := MachineInstanceID(""); err == nil {
+		t.Fail()
+	}
+}
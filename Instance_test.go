@@ -0,0 +1,37 @@
+package apireg
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestThatGroupByInstanceGroupsAndOrders(t *testing.T) {
+	id0 := uuid.New()
+	id1 := uuid.New()
+	a0, _ := NewApi("B", NewVersion(1, 0, 0), id0, All, net.ParseIP("192.168.0.3"), 80)
+	a1, _ := NewApi("A", NewVersion(1, 0, 0), id0, All, net.ParseIP("192.168.0.3"), 81)
+	a2, _ := NewApi("A", NewVersion(1, 0, 0), id1, All, net.ParseIP("192.168.0.4"), 80)
+
+	instances := GroupByInstance([]Api{a0, a1, a2})
+
+	if len(instances) != 2 || bytes.Compare(instances[0].ID[:], instances[1].ID[:]) >= 0 {
+		t.Fatal("expected 2 instances ordered by ID")
+	}
+	for _, curInstance := range instances {
+		if curInstance.ID == id0 && (len(curInstance.Apis) != 2 || curInstance.Apis[0] != a1) {
+			t.Fail()
+		}
+		if curInstance.ID == id1 && len(curInstance.Apis) != 1 {
+			t.Fail()
+		}
+	}
+}
+
+func TestThatGroupByInstanceOfNothingIsEmpty(t *testing.T) {
+	if instances := GroupByInstance(nil); instances == nil || len(instances) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,13 @@
+package apireg
+
+type Protocol string
+
+const (
+	//Protocol was not given by the registering application
+	UnknownProtocol Protocol = ""
+	HTTP            Protocol = "http"
+	HTTPS           Protocol = "https"
+	GRPC            Protocol = "grpc"
+	TCP             Protocol = "tcp"
+	UDP             Protocol = "udp"
+)
@@ -0,0 +1,100 @@
+package apireg
+
+import (
+	"bytes"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Query describes which apis to Find. Zero valued fields match everything so an empty Query matches every api
+type Query struct {
+	//Exact api name
+	Name string
+	//Glob for the api name as in path.Match, ex "SMDS*" for every name starting with SMDS
+	NameGlob string
+	//Version the api must meet, see ParseConstraint
+	Version Constraint
+	//Exact environment the api was registered in
+	Environment Environment
+	//Exact host ip of the api
+	HostIP net.IP
+	//Network the host ip of the api must be in, ex 192.168.1.0/24
+	HostNetwork *net.IPNet
+	//UUID of the instance that published the api
+	Instance uuid.UUID
+	//Every key must be set to the given value in the api's metadata
+	Metadata map[string]string
+}
+
+// Matches reports if a meets every field set in the query
+func (this *Query) Matches(a Api) bool {
+	if a == nil {
+		return false
+	}
+	if this.Name != "" && a.Name() != this.Name {
+		return false
+	}
+	if this.NameGlob != "" {
+		//A bad pattern can't match anything
+		if matched, err := path.Match(this.NameGlob, a.Name()); err != nil || !matched {
+			return false
+		}
+	}
+	if this.Version != nil && !this.Version.Check(a.Version()) {
+		return false
+	}
+	if this.Environment != "" && a.Environment() != this.Environment {
+		return false
+	}
+	if this.HostIP != nil && !this.HostIP.Equal(a.HostIP()) {
+		return false
+	}
+	if this.HostNetwork != nil && !this.HostNetwork.Contains(a.HostIP()) {
+		return false
+	}
+	if this.Instance != uuid.Nil && a.UUID() != this.Instance {
+		return false
+	}
+	for key, value := range this.Metadata {
+		if curValue, contains := a.MetadataValue(key); !contains || curValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SortApis puts apis in a stable order: by name, then highest version first, then host address, port and instance
+func SortApis(apis []Api) {
+	sort.SliceStable(apis, func(i, j int) bool {
+		return compareApis(apis[i], apis[j]) < 0
+	})
+}
+
+func compareApis(a0, a1 Api) int {
+	if c := strings.Compare(a0.Name(), a1.Name()); c != 0 {
+		return c
+	}
+	//Newest first as that is usually the one people want
+	if c := a1.Version().Compare(a0.Version()); c != 0 {
+		return c
+	}
+	if c := bytes.Compare(a0.HostIP().To16(), a1.HostIP().To16()); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a0.HostZone(), a1.HostZone()); c != 0 {
+		return c
+	}
+	if a0.HostPort() != a1.HostPort() {
+		if a0.HostPort() < a1.HostPort() {
+			return -1
+		}
+		return 1
+	}
+	a0UUID := a0.UUID()
+	a1UUID := a1.UUID()
+	return bytes.Compare(a0UUID[:], a1UUID[:])
+}
@@ -0,0 +1,69 @@
+package apireg
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestQueryMatches(t *testing.T) {
+	instance := uuid.New()
+	a, _ := NewApi("SMDS-North", NewVersion(1, 4, 0), instance, Prod, net.ParseIP("192.168.1.20"), 8080,
+		WithMetadata(map[string]string{"zone": "shed-2", "scheme": "https"}))
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	_, otherLan, _ := net.ParseCIDR("10.0.0.0/8")
+	caret13, _ := ParseConstraint("^1.3")
+	caret2, _ := ParseConstraint("^2")
+
+	tests := []struct {
+		desc  string
+		query Query
+		want  bool
+	}{
+		{"empty query", Query{}, true},
+		{"exact name", Query{Name: "SMDS-North"}, true},
+		{"wrong name", Query{Name: "SMDS"}, false},
+		{"name glob", Query{NameGlob: "SMDS*"}, true},
+		{"name glob miss", Query{NameGlob: "TCC*"}, false},
+		{"bad glob", Query{NameGlob: "["}, false},
+		{"version", Query{Version: caret13}, true},
+		{"version miss", Query{Version: caret2}, false},
+		{"environment", Query{Environment: Prod}, true},
+		{"environment miss", Query{Environment: NonProd}, false},
+		{"host ip", Query{HostIP: net.ParseIP("192.168.1.20")}, true},
+		{"host ip miss", Query{HostIP: net.ParseIP("192.168.1.21")}, false},
+		{"host network", Query{HostNetwork: lan}, true},
+		{"host network miss", Query{HostNetwork: otherLan}, false},
+		{"instance", Query{Instance: instance}, true},
+		{"instance miss", Query{Instance: uuid.New()}, false},
+		{"metadata", Query{Metadata: map[string]string{"zone": "shed-2"}}, true},
+		{"metadata wrong value", Query{Metadata: map[string]string{"zone": "shed-1"}}, false},
+		{"metadata missing key", Query{Metadata: map[string]string{"build": "abc"}}, false},
+		{"everything", Query{NameGlob: "SMDS*", Version: caret13, Environment: Prod, HostNetwork: lan, Metadata: map[string]string{"scheme": "https"}}, true},
+	}
+
+	for _, curTest := range tests {
+		if got := curTest.query.Matches(a); got != curTest.want {
+			t.Errorf("%s: Matches = %t, want %t", curTest.desc, got, curTest.want)
+		}
+	}
+}
+
+func TestThatSortApisOrdersByNameThenNewestVersionThenAddress(t *testing.T) {
+	id := uuid.New()
+	b1, _ := NewApi("B", NewVersion(1, 0, 0), id, All, net.ParseIP("192.168.1.2"), 80)
+	a1, _ := NewApi("A", NewVersion(1, 0, 0), id, All, net.ParseIP("192.168.1.2"), 80)
+	a2High, _ := NewApi("A", NewVersion(2, 0, 0), id, All, net.ParseIP("192.168.1.9"), 80)
+	a2Low, _ := NewApi("A", NewVersion(2, 0, 0), id, All, net.ParseIP("192.168.1.3"), 80)
+
+	apis := []Api{b1, a1, a2High, a2Low}
+	SortApis(apis)
+
+	want := []Api{a2Low, a2High, a1, b1}
+	for i, curApi := range apis {
+		if curApi != want[i] {
+			t.Errorf("index %d = %s %s %s", i, curApi.Name(), curApi.Version(), curApi.HostIP())
+		}
+	}
+}
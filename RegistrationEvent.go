@@ -3,18 +3,40 @@ package apireg
 type EventType string
 
 const (
-	Added   EventType = "add"
+	Added EventType = "add"
+	//Removed is a removal without a reason. Registries send Expired or Deregistered instead so check IsRemoval to catch all of them.
+	//Watch and Subscribe also send it when an update means an api no longer matches their query
 	Removed EventType = "remove"
+	//Updated is an api we already knew about that changed, ex its metadata or life span. Previous has what it was before
+	Updated EventType = "update"
+	//Expired is an api we didn't hear from again before its life span ran out, ex its server crashed
+	Expired EventType = "expire"
+	//Deregistered is an api that was taken down on purpose, ex UnregisterApi or a clean Close
+	Deregistered EventType = "deregister"
 )
 
+// IsRemoval is true for any event type that means the api is gone
+func (this EventType) IsRemoval() bool {
+	return this == Removed || this == Expired || this == Deregistered
+}
+
 type RegistrationEvent interface {
 	Type() EventType
 	Api() Api
+	//Previous is the api before an Updated event. nil for every other type
+	Previous() Api
+	//Missed is how many events were dropped right before this one because a watcher fell behind. Always 0 for listeners
+	Missed() uint
+	//Revision of the registry right after this event happened. Goes up by one for every event
+	Revision() uint64
 }
 
 type eventImpl struct {
-	eType EventType
-	api   Api
+	eType    EventType
+	api      Api
+	previous Api
+	missed   uint
+	revision uint64
 }
 
 func (this *eventImpl) Type() EventType {
@@ -25,6 +47,18 @@ func (this *eventImpl) Api() Api {
 	return this.api
 }
 
+func (this *eventImpl) Previous() Api {
+	return this.previous
+}
+
+func (this *eventImpl) Missed() uint {
+	return this.missed
+}
+
+func (this *eventImpl) Revision() uint64 {
+	return this.revision
+}
+
 func NewAddEvent(a Api) RegistrationEvent {
 	if a != nil {
 		e := &eventImpl{}
@@ -44,3 +78,58 @@ func NewRemovedEvent(a Api) RegistrationEvent {
 	}
 	return nil
 }
+
+func NewUpdatedEvent(a Api, previous Api) RegistrationEvent {
+	if a != nil && previous != nil {
+		e := &eventImpl{}
+		e.eType = Updated
+		e.api = a
+		e.previous = previous
+		return e
+	}
+	return nil
+}
+
+func NewExpiredEvent(a Api) RegistrationEvent {
+	if a != nil {
+		e := &eventImpl{}
+		e.eType = Expired
+		e.api = a
+		return e
+	}
+	return nil
+}
+
+func NewDeregisteredEvent(a Api) RegistrationEvent {
+	if a != nil {
+		e := &eventImpl{}
+		e.eType = Deregistered
+		e.api = a
+		return e
+	}
+	return nil
+}
+
+// EventWithMissed copies e with Missed set to missed
+func EventWithMissed(e RegistrationEvent, missed uint) RegistrationEvent {
+	if e == nil {
+		return nil
+	}
+	eCopy := copyEvent(e)
+	eCopy.missed = missed
+	return eCopy
+}
+
+// EventWithRevision copies e with Revision set to revision
+func EventWithRevision(e RegistrationEvent, revision uint64) RegistrationEvent {
+	if e == nil {
+		return nil
+	}
+	eCopy := copyEvent(e)
+	eCopy.revision = revision
+	return eCopy
+}
+
+func copyEvent(e RegistrationEvent) *eventImpl {
+	return &eventImpl{eType: e.Type(), api: e.Api(), previous: e.Previous(), missed: e.Missed(), revision: e.Revision()}
+}
@@ -0,0 +1,51 @@
+package apireg
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestThatOnlyRemovalEventTypesAreRemovals(t *testing.T) {
+	for _, curType := range []EventType{Removed, Expired, Deregistered} {
+		if !curType.IsRemoval() {
+			t.Error(curType, "should be a removal")
+		}
+	}
+	for _, curType := range []EventType{Added, Updated} {
+		if curType.IsRemoval() {
+			t.Error(curType, "should not be a removal")
+		}
+	}
+}
+
+func TestThatUpdatedEventKeepsPreviousApi(t *testing.T) {
+	id := uuid.New()
+	prev, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80)
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), id, All, net.ParseIP("192.168.0.3"), 80, WithProtocol(HTTP))
+
+	e := EventWithRevision(NewUpdatedEvent(a, prev), 4)
+
+	if e.Type() != Updated || e.Api() != a || e.Previous() != prev || e.Revision() != 4 {
+		t.Fail()
+	}
+}
+
+func TestThatUpdatedEventNeedsBothApis(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 80)
+
+	if NewUpdatedEvent(a, nil) != nil || NewUpdatedEvent(nil, a) != nil {
+		t.Fail()
+	}
+}
+
+func TestThatOnlyUpdatedEventsHaveAPreviousApi(t *testing.T) {
+	a, _ := NewApi("Something", NewVersion(0, 0, 1), uuid.New(), All, net.ParseIP("192.168.0.3"), 80)
+
+	for _, curE := range []RegistrationEvent{NewAddEvent(a), NewExpiredEvent(a), NewDeregisteredEvent(a)} {
+		if curE.Previous() != nil {
+			t.Error(curE.Type(), "has a previous api")
+		}
+	}
+}
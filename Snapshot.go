@@ -0,0 +1,8 @@
+package apireg
+
+// Snapshot is every api matching a subscription at a single revision of the registry
+type Snapshot struct {
+	//Revision the apis were taken at, events after this one will have a higher Revision
+	Revision uint64
+	Apis     []Api
+}
@@ -1,27 +1,132 @@
 package apireg
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 type Version interface {
 	Major() uint
 	Minor() uint
 	BugFix() uint
+	//PreRelease is the part after the '-' ex "rc.1" in v1.2.3-rc.1. Empty for a release
+	PreRelease() string
+	//Build is the metadata after the '+' ex "abc123" in v1.2.3+abc123. Ignored when ordering versions
+	Build() string
+	//Equal is true only if every part including build metadata matches
 	Equal(Version) bool
+	//Compare orders versions per SemVer 2.0. Returns -1 if this is lower, 0 if same precedence and 1 if higher
+	Compare(Version) int
 	LessThan(Version) bool
 	GreaterThan(Version) bool
 	String() string
 }
 
 type version struct {
-	MajorVal  uint
-	MinorVal  uint
-	BugFixVal uint
+	MajorVal      uint
+	MinorVal      uint
+	BugFixVal     uint
+	PreReleaseVal string
+	BuildVal      string
 }
 
 func NewVersion(major, minor, bugfix uint) Version {
 	return &version{MajorVal: major, MinorVal: minor, BugFixVal: bugfix}
 }
 
+// NewSemanticVersion is NewVersion with pre-release and build metadata. Both are dot separated identifiers of [0-9A-Za-z-] and can be empty
+func NewSemanticVersion(major, minor, bugfix uint, preRelease, build string) (Version, error) {
+	if preRelease != "" {
+		if err := validateIdentifiers(preRelease, true); err != nil {
+			return nil, errors.New(fmt.Sprint("invalid pre-release ", preRelease, ": ", err))
+		}
+	}
+	if build != "" {
+		if err := validateIdentifiers(build, false); err != nil {
+			return nil, errors.New(fmt.Sprint("invalid build metadata ", build, ": ", err))
+		}
+	}
+	return &version{MajorVal: major, MinorVal: minor, BugFixVal: bugfix, PreReleaseVal: preRelease, BuildVal: build}, nil
+}
+
+// ParseVersion reads a version like "v1.2.3", "1.2.3-rc.1" or "v1.2.3-beta+abc123". The leading 'v' is optional
+func ParseVersion(s string) (Version, error) {
+	remaining := strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	var build, preRelease string
+	if i := strings.IndexByte(remaining, '+'); i >= 0 {
+		build = remaining[i+1:]
+		remaining = remaining[:i]
+		if build == "" {
+			return nil, errors.New(fmt.Sprint("version ", s, " has an empty build after '+'"))
+		}
+	}
+	if i := strings.IndexByte(remaining, '-'); i >= 0 {
+		preRelease = remaining[i+1:]
+		remaining = remaining[:i]
+		if preRelease == "" {
+			return nil, errors.New(fmt.Sprint("version ", s, " has an empty pre-release after '-'"))
+		}
+	}
+
+	parts := strings.Split(remaining, ".")
+	if len(parts) != 3 {
+		return nil, errors.New(fmt.Sprint("version ", s, " must have major.minor.bugfix"))
+	}
+	nums := make([]uint, 3)
+	for i, curPart := range parts {
+		num, err := parseVersionNumber(curPart)
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("version ", s, ": ", err))
+		}
+		nums[i] = num
+	}
+
+	return NewSemanticVersion(nums[0], nums[1], nums[2], preRelease, build)
+}
+
+func parseVersionNumber(s string) (uint, error) {
+	if s == "" {
+		return 0, errors.New("empty version number")
+	} else if len(s) > 1 && s[0] == '0' {
+		return 0, errors.New(fmt.Sprint("version number ", s, " has a leading zero"))
+	}
+	num, err := strconv.ParseUint(s, 10, 0)
+	if err != nil {
+		return 0, errors.New(fmt.Sprint("version number ", s, " is not a number"))
+	}
+	return uint(num), nil
+}
+
+func validateIdentifiers(s string, noLeadingZeros bool) error {
+	for _, curId := range strings.Split(s, ".") {
+		if curId == "" {
+			return errors.New("empty identifier")
+		}
+		for _, curChar := range curId {
+			if !(curChar >= '0' && curChar <= '9') && !(curChar >= 'a' && curChar <= 'z') && !(curChar >= 'A' && curChar <= 'Z') && curChar != '-' {
+				return errors.New(fmt.Sprint("identifier ", curId, " has invalid character ", string(curChar)))
+			}
+		}
+		if noLeadingZeros && isNumericIdentifier(curId) && len(curId) > 1 && curId[0] == '0' {
+			return errors.New(fmt.Sprint("numeric identifier ", curId, " has a leading zero"))
+		}
+	}
+	return nil
+}
+
+func isNumericIdentifier(id string) bool {
+	for _, curChar := range id {
+		if curChar < '0' || curChar > '9' {
+			return false
+		}
+	}
+	return id != ""
+}
+
 func (this *version) Major() uint {
 	return this.MajorVal
 }
@@ -34,18 +139,128 @@ func (this *version) BugFix() uint {
 	return this.BugFixVal
 }
 
+func (this *version) PreRelease() string {
+	return this.PreReleaseVal
+}
+
+func (this *version) Build() string {
+	return this.BuildVal
+}
+
 func (this *version) Equal(other Version) bool {
-	return this.MajorVal == other.Major() && this.MinorVal == other.Minor() && this.BugFixVal == other.BugFix()
+	return this.MajorVal == other.Major() && this.MinorVal == other.Minor() && this.BugFixVal == other.BugFix() &&
+		this.PreReleaseVal == other.PreRelease() && this.BuildVal == other.Build()
+}
+
+func (this *version) Compare(other Version) int {
+	if c := compareUint(this.MajorVal, other.Major()); c != 0 {
+		return c
+	}
+	if c := compareUint(this.MinorVal, other.Minor()); c != 0 {
+		return c
+	}
+	if c := compareUint(this.BugFixVal, other.BugFix()); c != 0 {
+		return c
+	}
+	return comparePreRelease(this.PreReleaseVal, other.PreRelease())
+}
+
+func compareUint(a, b uint) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func comparePreRelease(a, b string) int {
+	//A release is higher than any of its pre-releases
+	if a == b {
+		return 0
+	} else if a == "" {
+		return 1
+	} else if b == "" {
+		return -1
+	}
+
+	aIds := strings.Split(a, ".")
+	bIds := strings.Split(b, ".")
+	for i := 0; i < len(aIds) && i < len(bIds); i++ {
+		if c := compareIdentifier(aIds[i], bIds[i]); c != 0 {
+			return c
+		}
+	}
+	//All shared identifiers matched so the longer one is higher
+	if len(aIds) < len(bIds) {
+		return -1
+	} else if len(aIds) > len(bIds) {
+		return 1
+	}
+	return 0
+}
+
+func compareIdentifier(a, b string) int {
+	aNumeric := isNumericIdentifier(a)
+	bNumeric := isNumericIdentifier(b)
+
+	if aNumeric && bNumeric {
+		//No leading zeros so the longer number is the bigger one which avoids overflowing on huge identifiers
+		if len(a) != len(b) {
+			return compareUint(uint(len(a)), uint(len(b)))
+		}
+		return strings.Compare(a, b)
+	} else if aNumeric {
+		//Numeric identifiers are always lower than alphanumeric ones
+		return -1
+	} else if bNumeric {
+		return 1
+	}
+	return strings.Compare(a, b)
 }
 
 func (this *version) LessThan(other Version) bool {
-	return this.MajorVal <= other.Major() && this.MinorVal <= other.Minor() && this.BugFixVal < other.BugFix()
+	return this.Compare(other) < 0
 }
 
 func (this *version) GreaterThan(other Version) bool {
-	return this.MajorVal >= other.Major() && this.MinorVal >= other.Minor() && this.BugFixVal > other.BugFix()
+	return this.Compare(other) > 0
 }
 
 func (this *version) String() string {
-	return fmt.Sprintf("v%d.%d.%d", this.MajorVal, this.MinorVal, this.BugFixVal)
+	s := fmt.Sprintf("v%d.%d.%d", this.MajorVal, this.MinorVal, this.BugFixVal)
+	if this.PreReleaseVal != "" {
+		s += "-" + this.PreReleaseVal
+	}
+	if this.BuildVal != "" {
+		s += "+" + this.BuildVal
+	}
+	return s
+}
+
+// Versions sorts lowest to highest with sort.Sort
+type Versions []Version
+
+func (this Versions) Len() int {
+	return len(this)
+}
+
+func (this Versions) Less(i, j int) bool {
+	return this[i].LessThan(this[j])
+}
+
+func (this Versions) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+// SortVersions sorts vs in place from lowest to highest version
+func SortVersions(vs []Version) {
+	sort.Stable(Versions(vs))
+}
+
+// SortApisByVersion sorts apis in place from lowest to highest version, apis with the same version keep their order
+func SortApisByVersion(apis []Api) {
+	sort.SliceStable(apis, func(i, j int) bool {
+		return apis[i].Version().LessThan(apis[j].Version())
+	})
 }
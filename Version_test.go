@@ -69,7 +69,7 @@ func TestVersionGreaterThanReturnsTrueWhenVersion0IsHigherThanVersion1(t *testin
 	v0 := NewVersion(1, 1, 1)
 	v1 := NewVersion(1, 0, 1)
 
-	if v0.GreaterThan(v1) {
+	if !v0.GreaterThan(v1) {
 		t.Fail()
 	}
 }
@@ -81,3 +81,108 @@ func TestThatVersionStringReturnsCorrect(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		v0, v1 string
+		want   int
+	}{
+		{"v1.9.0", "v2.0.0", -1},
+		{"v2.0.0", "v1.9.0", 1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3+abc", "v1.2.3+def", 0},
+		{"v1.0.0-alpha", "v1.0.0", -1},
+		{"v1.0.0", "v1.0.0-rc.1", 1},
+		//Precedence example straight from the SemVer 2.0 spec
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-alpha.beta", "v1.0.0-beta", -1},
+		{"v1.0.0-beta", "v1.0.0-beta.2", -1},
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-beta.11", "v1.0.0-rc.1", -1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+	}
+
+	for _, curTest := range tests {
+		v0, err := ParseVersion(curTest.v0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v1, err := ParseVersion(curTest.v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v0.Compare(v1); got != curTest.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", curTest.v0, curTest.v1, got, curTest.want)
+		}
+		if v0.LessThan(v1) != (curTest.want < 0) || v0.GreaterThan(v1) != (curTest.want > 0) {
+			t.Errorf("LessThan/GreaterThan disagree with Compare for %s and %s", curTest.v0, curTest.v1)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"v1.2.3", "v1.2.3", false},
+		{"1.2.3", "v1.2.3", false},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1", false},
+		{"v1.2.3-beta+abc123", "v1.2.3-beta+abc123", false},
+		{"v1.2.3+build.7", "v1.2.3+build.7", false},
+		{"v1.2", "", true},
+		{"v1.2.3.4", "", true},
+		{"v01.2.3", "", true},
+		{"v1.x.3", "", true},
+		{"v1.2.3-", "", true},
+		{"v1.2.3+", "", true},
+		{"v1.2.3-rc..1", "", true},
+		{"v1.2.3-rc.01", "", true},
+		{"v1.2.3-rc$", "", true},
+		{"", "", true},
+	}
+
+	for _, curTest := range tests {
+		v, err := ParseVersion(curTest.in)
+		if curTest.wantErr {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) expected an error", curTest.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q) returned error %v", curTest.in, err)
+		} else if v.String() != curTest.want {
+			t.Errorf("ParseVersion(%q) = %s, want %s", curTest.in, v, curTest.want)
+		}
+	}
+}
+
+func TestThatVersionsWithDifferentBuildAreNotEqual(t *testing.T) {
+	v0, _ := ParseVersion("v1.2.3+abc")
+	v1, _ := ParseVersion("v1.2.3+def")
+
+	if v0.Equal(v1) {
+		t.Fail()
+	}
+}
+
+func TestThatSortVersionsOrdersLowestToHighest(t *testing.T) {
+	in := []string{"v2.0.0", "v1.0.0-rc.1", "v1.10.0", "v1.0.0", "v1.9.0"}
+	want := []string{"v1.0.0-rc.1", "v1.0.0", "v1.9.0", "v1.10.0", "v2.0.0"}
+
+	vs := make([]Version, len(in))
+	for i, curIn := range in {
+		vs[i], _ = ParseVersion(curIn)
+	}
+	SortVersions(vs)
+
+	for i, curV := range vs {
+		if curV.String() != want[i] {
+			t.Errorf("index %d = %s, want %s", i, curV, want[i])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package apiregtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+const pollInterval time.Duration = time.Millisecond * 5
+
+// Converged returns nil if every node sees exactly the apis registered on the other nodes it can reach and shares an
+// environment with. Otherwise the error says what the first node that doesn't is missing or has extra
+func (this *Network) Converged() error {
+	nodes := this.Nodes()
+	for _, curNode := range nodes {
+		want := make(map[registrationKey]int)
+		for _, curPeer := range nodes {
+			if curPeer == curNode || !this.CanReach(curPeer, curNode) || !environmentsMatch(curNode.environment, curPeer.environment) {
+				continue
+			}
+			for _, curKey := range curPeer.registrations() {
+				want[curKey]++
+			}
+		}
+		have := make(map[registrationKey]int)
+		for _, curApi := range curNode.GetAvailableApis() {
+			have[newRegistrationKey(curApi.Name(), curApi.Version(), curApi.HostPort())]++
+		}
+
+		if diff := diffRegistrations(want, have); diff != "" {
+			return errors.New(fmt.Sprint("node ", curNode.Addr.IP, " ", diff))
+		}
+	}
+	return nil
+}
+
+// environmentsMatch is whether registries in the two environments listen to each other
+func environmentsMatch(e0, e1 apireg.Environment) bool {
+	return e0 == apireg.All || e1 == apireg.All || e0 == e1
+}
+
+func diffRegistrations(want, have map[registrationKey]int) string {
+	diffs := make([]string, 0)
+	for curKey, curCount := range want {
+		if have[curKey] < curCount {
+			diffs = append(diffs, fmt.Sprint("is missing ", curKey))
+		}
+	}
+	for curKey, curCount := range have {
+		if want[curKey] < curCount {
+			diffs = append(diffs, fmt.Sprint("has extra ", curKey))
+		}
+	}
+	//Same message for the same problem every time
+	sort.Strings(diffs)
+	if len(diffs) == 0 {
+		return ""
+	}
+	return fmt.Sprint(diffs)
+}
+
+// EventuallyConverges waits up to timeout for Converged, failing t with what was still different if it never happens
+func EventuallyConverges(t testing.TB, n *Network, timeout time.Duration) bool {
+	t.Helper()
+	var err error
+	converged := poll(timeout, func() bool {
+		err = n.Converged()
+		return err == nil
+	})
+	if !converged {
+		t.Error("network did not converge within ", timeout, ": ", err)
+	}
+	return converged
+}
+
+// Eventually waits up to timeout for cond to return true, failing t with msg if it never does
+func Eventually(t testing.TB, timeout time.Duration, cond func() bool, msg ...any) bool {
+	t.Helper()
+	if !poll(timeout, cond) {
+		t.Error(append([]any{"condition not met within ", timeout, ": "}, msg...)...)
+		return false
+	}
+	return true
+}
+
+func poll(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,213 @@
+package apiregtest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/ZacharyDuve/apireg/internal/netutil"
+	"github.com/ZacharyDuve/apireg/multicast"
+	"github.com/google/uuid"
+)
+
+const (
+	//Registries on a Network resend and expire much faster than the real defaults so tests don't wait around
+	DefaultUpdateInterval time.Duration = time.Millisecond * 50
+	DefaultLifeSpan       time.Duration = DefaultUpdateInterval * 4
+	nodeInboxSize         int           = 1024
+)
+
+var errNetworkClosed = errors.New("network has been closed")
+
+// Network is a simulated LAN for registries. Every node gets its own address and hears everything the others send,
+// unless the conditions set on the network get in the way. Conditions can be changed at any time
+type Network struct {
+	nodes      []*Node
+	nextIP     net.IP
+	conditions Conditions
+	//Nodes in different groups can't hear each other, nil when there is no partition
+	partitions map[*Node]int
+	random     *rand.Rand
+	mutex      *sync.Mutex
+	closed     bool
+}
+
+// Conditions are what can go wrong with a datagram on its way to each receiver. The zero value is a perfect network
+type Conditions struct {
+	//Chance from 0 to 1 that a datagram never arrives
+	Loss float64
+	//Chance from 0 to 1 that a datagram arrives twice
+	Duplication float64
+	//How long datagrams take to arrive, picked evenly between the two for each one so it also reorders them
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	//Chance from 0 to 1 that a datagram is held back an extra ReorderDelay so ones sent after it arrive first
+	Reorder      float64
+	ReorderDelay time.Duration
+}
+
+// NewNetwork makes an empty network. seed makes the random conditions repeatable
+func NewNetwork(seed int64) *Network {
+	n := &Network{}
+	n.nodes = make([]*Node, 0)
+	n.nextIP = net.IPv4(10, 0, 0, 0).To4()
+	n.random = rand.New(rand.NewSource(seed))
+	n.mutex = &sync.Mutex{}
+	return n
+}
+
+// SetConditions changes the conditions for every datagram sent from now on
+func (this *Network) SetConditions(c Conditions) {
+	this.mutex.Lock()
+	this.conditions = c
+	this.mutex.Unlock()
+}
+
+// Partition splits the network so nodes only hear the others in their group. Nodes left out of every group end up
+// together in a group of their own
+func (this *Network) Partition(groups ...[]*Node) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.partitions = make(map[*Node]int)
+	for i, curGroup := range groups {
+		for _, curNode := range curGroup {
+			//0 is for the nodes nobody mentioned
+			this.partitions[curNode] = i + 1
+		}
+	}
+}
+
+// Heal removes any partition so every node hears every other again
+func (this *Network) Heal() {
+	this.mutex.Lock()
+	this.partitions = nil
+	this.mutex.Unlock()
+}
+
+// AddRegistry starts a registry on the network. It uses DefaultUpdateInterval and DefaultLifeSpan unless opts say
+// otherwise, everything in opts is passed on to multicast.NewMulticastRegistry
+func (this *Network) AddRegistry(e apireg.Environment, opts ...multicast.Option) (*Node, error) {
+	this.mutex.Lock()
+	if this.closed {
+		this.mutex.Unlock()
+		return nil, errNetworkClosed
+	}
+	this.nextIP = netutil.NextIP(this.nextIP)
+	node := &Node{network: this, environment: e, ID: uuid.New(), Addr: &net.UDPAddr{IP: this.nextIP, Port: multicast.DEFAULT_MULTICAST_GROUP_PORT}}
+	node.inbox = make(chan datagram, nodeInboxSize)
+	node.closed = make(chan struct{})
+	node.registered = make(map[registrationKey]bool)
+	node.registeredMutex = &sync.Mutex{}
+	this.nodes = append(this.nodes, node)
+	this.mutex.Unlock()
+
+	allOpts := []multicast.Option{multicast.WithUpdateInterval(DefaultUpdateInterval), multicast.WithLifeSpan(DefaultLifeSpan)}
+	allOpts = append(allOpts, opts...)
+	allOpts = append(allOpts, multicast.WithTransport(&nodeTransport{node}))
+	r, err := multicast.NewMulticastRegistry(nil, e, node.ID, allOpts...)
+	if err != nil {
+		this.remove(node)
+		return nil, err
+	}
+	node.ApiRegistry = r
+	return node, nil
+}
+
+// AddRegistries starts n registries on the network, see AddRegistry
+func (this *Network) AddRegistries(n int, e apireg.Environment, opts ...multicast.Option) ([]*Node, error) {
+	nodes := make([]*Node, 0, n)
+	for i := 0; i < n; i++ {
+		node, err := this.AddRegistry(e, opts...)
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Nodes is every node still on the network
+func (this *Network) Nodes() []*Node {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	nodes := make([]*Node, len(this.nodes))
+	copy(nodes, this.nodes)
+	return nodes
+}
+
+// Close closes every registry on the network
+func (this *Network) Close() error {
+	this.mutex.Lock()
+	this.closed = true
+	this.mutex.Unlock()
+
+	var firstErr error
+	for _, curNode := range this.Nodes() {
+		if err := curNode.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CanReach is true if datagrams from one node can get to the other, ignoring loss
+func (this *Network) CanReach(from, to *Node) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.canReachLocked(from, to)
+}
+
+func (this *Network) canReachLocked(from, to *Node) bool {
+	if from.isClosed() || to.isClosed() {
+		return false
+	}
+	return this.partitions == nil || this.partitions[from] == this.partitions[to]
+}
+
+func (this *Network) remove(node *Node) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for i, curNode := range this.nodes {
+		if curNode == node {
+			this.nodes = append(this.nodes[:i], this.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (this *Network) send(from *Node, data []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, curNode := range this.nodes {
+		if !this.canReachLocked(from, curNode) {
+			continue
+		}
+		copies := 1
+		if this.random.Float64() < this.conditions.Loss {
+			copies = 0
+		} else if this.random.Float64() < this.conditions.Duplication {
+			copies = 2
+		}
+		for i := 0; i < copies; i++ {
+			d := datagram{data: make([]byte, len(data)), from: from.Addr}
+			copy(d.data, data)
+			curNode.deliverAfter(d, this.latencyLocked())
+		}
+	}
+}
+
+func (this *Network) latencyLocked() time.Duration {
+	c := this.conditions
+	latency := c.MinLatency
+	if c.MaxLatency > c.MinLatency {
+		latency += time.Duration(this.random.Int63n(int64(c.MaxLatency - c.MinLatency)))
+	}
+	if this.random.Float64() < c.Reorder {
+		latency += c.ReorderDelay
+	}
+	return latency
+}
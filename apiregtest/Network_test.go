@@ -0,0 +1,155 @@
+package apiregtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+const convergeTimeout time.Duration = time.Second * 5
+
+func newTestNetwork(t *testing.T, n int) (*Network, []*Node) {
+	network := NewNetwork(1)
+	t.Cleanup(func() { network.Close() })
+	nodes, err := network.AddRegistries(n, apireg.All)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network, nodes
+}
+
+func registerOnePerNode(t *testing.T, nodes []*Node) {
+	for i, curNode := range nodes {
+		if err := curNode.RegisterApi(fmt.Sprint("Api", i), apireg.NewVersion(1, 0, 0), 8000+i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestThatPerfectNetworkConverges(t *testing.T) {
+	network, nodes := newTestNetwork(t, 5)
+	registerOnePerNode(t, nodes)
+
+	EventuallyConverges(t, network, convergeTimeout)
+	if len(nodes[0].GetAvailableApis()) != 4 {
+		t.Fail()
+	}
+}
+
+func TestThatNodesLookLikeDifferentHosts(t *testing.T) {
+	_, nodes := newTestNetwork(t, 2)
+	nodes[0].RegisterApi("Api", apireg.NewVersion(1, 0, 0), 8000)
+
+	Eventually(t, convergeTimeout, func() bool { return len(nodes[1].GetApisByApiName("Api")) == 1 })
+	if !nodes[1].GetApisByApiName("Api")[0].HostIP().Equal(nodes[0].Addr.IP) {
+		t.Fail()
+	}
+}
+
+func TestThatBadNetworkStillConverges(t *testing.T) {
+	network, nodes := newTestNetwork(t, 5)
+	network.SetConditions(Conditions{
+		Loss:         0.3,
+		Duplication:  0.2,
+		MinLatency:   time.Millisecond,
+		MaxLatency:   time.Millisecond * 10,
+		Reorder:      0.2,
+		ReorderDelay: time.Millisecond * 20,
+	})
+	registerOnePerNode(t, nodes)
+
+	EventuallyConverges(t, network, convergeTimeout)
+}
+
+func TestThatNetworkIsNotConvergedWhileDatagramsAreInFlight(t *testing.T) {
+	network, nodes := newTestNetwork(t, 2)
+	network.SetConditions(Conditions{MinLatency: time.Hour, MaxLatency: time.Hour})
+	nodes[0].RegisterApi("Slow", apireg.NewVersion(1, 0, 0), 8000)
+
+	if network.Converged() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatPartitionedNodesExpireEachOtherAndRecoverAfterHealing(t *testing.T) {
+	network, nodes := newTestNetwork(t, 4)
+	registerOnePerNode(t, nodes)
+	EventuallyConverges(t, network, convergeTimeout)
+
+	network.Partition(nodes[:1])
+	EventuallyConverges(t, network, convergeTimeout)
+	if len(nodes[0].GetAvailableApis()) != 0 || len(nodes[1].GetAvailableApis()) != 2 {
+		t.Fail()
+	}
+
+	network.Heal()
+	EventuallyConverges(t, network, convergeTimeout)
+	if len(nodes[0].GetAvailableApis()) != 3 {
+		t.Fail()
+	}
+}
+
+func TestThatCrashedNodeExpiresFromPeers(t *testing.T) {
+	network, nodes := newTestNetwork(t, 3)
+	registerOnePerNode(t, nodes)
+	EventuallyConverges(t, network, convergeTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := nodes[1].Watch(ctx, apireg.Query{Name: "Api0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].Crash()
+
+	EventuallyConverges(t, network, convergeTimeout)
+	select {
+	case e := <-events:
+		if e.Type() != apireg.Expired {
+			t.Error("crash was seen as ", e.Type())
+		}
+	case <-time.After(convergeTimeout):
+		t.Error("never saw the crashed node's api go away")
+	}
+}
+
+func TestThatUnregisterAndCloseConverge(t *testing.T) {
+	network, nodes := newTestNetwork(t, 3)
+	registerOnePerNode(t, nodes)
+	EventuallyConverges(t, network, convergeTimeout)
+
+	nodes[0].UnregisterApi("Api0", apireg.NewVersion(1, 0, 0), 8000)
+	nodes[1].Close()
+
+	EventuallyConverges(t, network, convergeTimeout)
+	if len(network.Nodes()) != 2 || len(nodes[2].GetAvailableApis()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatNodesOnlyConvergeWithinTheirEnvironment(t *testing.T) {
+	network := NewNetwork(1)
+	defer network.Close()
+	prod, _ := network.AddRegistry(apireg.Prod)
+	nonProd, _ := network.AddRegistry(apireg.NonProd)
+	all, _ := network.AddRegistry(apireg.All)
+	prod.RegisterApi("Prod", apireg.NewVersion(1, 0, 0), 8000)
+	nonProd.RegisterApi("NonProd", apireg.NewVersion(1, 0, 0), 8000)
+
+	EventuallyConverges(t, network, convergeTimeout)
+	if len(all.GetAvailableApis()) != 2 || len(prod.GetAvailableApis()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatAddRegistryAfterCloseReturnsError(t *testing.T) {
+	network := NewNetwork(1)
+	network.Close()
+
+	if _, err := network.AddRegistry(apireg.All); err == nil {
+		t.Fail()
+	}
+}
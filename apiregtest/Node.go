@@ -0,0 +1,157 @@
+package apiregtest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+// Node is one registry on a Network. It is a normal apireg.ApiRegistry that also keeps track of what was registered
+// through it so EventuallyConverges knows what every other node should see
+type Node struct {
+	apireg.ApiRegistry
+	//Instance ID the registry was started with
+	ID uuid.UUID
+	//Where datagrams from this node come from, what peers see as the host of its apis
+	Addr *net.UDPAddr
+
+	network         *Network
+	environment     apireg.Environment
+	inbox           chan datagram
+	closed          chan struct{}
+	closeOnce       sync.Once
+	registered      map[registrationKey]bool
+	registeredMutex *sync.Mutex
+}
+
+type registrationKey struct {
+	name    string
+	version string
+	port    int
+}
+
+func newRegistrationKey(name string, version apireg.Version, port int) registrationKey {
+	return registrationKey{name: name, version: version.String(), port: port}
+}
+
+func (this registrationKey) String() string {
+	return fmt.Sprint(this.name, " ", this.version, " on port ", this.port)
+}
+
+type datagram struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+func (this *Node) RegisterApi(name string, version apireg.Version, port int, opts ...apireg.ApiOption) error {
+	err := this.ApiRegistry.RegisterApi(name, version, port, opts...)
+	if err == nil {
+		this.registeredMutex.Lock()
+		this.registered[newRegistrationKey(name, version, port)] = true
+		this.registeredMutex.Unlock()
+	}
+	return err
+}
+
+func (this *Node) UnregisterApi(name string, version apireg.Version, port int) error {
+	err := this.ApiRegistry.UnregisterApi(name, version, port)
+	if err == nil {
+		this.registeredMutex.Lock()
+		delete(this.registered, newRegistrationKey(name, version, port))
+		this.registeredMutex.Unlock()
+	}
+	return err
+}
+
+// Close closes the registry, which says goodbye to peers, and takes the node off the network
+func (this *Node) Close() error {
+	err := this.ApiRegistry.Close()
+	this.network.remove(this)
+	return err
+}
+
+// Crash takes the node off the network without the registry saying goodbye, so peers only find out when its
+// registrations expire
+func (this *Node) Crash() {
+	this.closeTransport()
+	this.network.remove(this)
+	this.ApiRegistry.Close()
+}
+
+func (this *Node) registrations() []registrationKey {
+	this.registeredMutex.Lock()
+	defer this.registeredMutex.Unlock()
+	regs := make([]registrationKey, 0, len(this.registered))
+	for curKey := range this.registered {
+		regs = append(regs, curKey)
+	}
+	return regs
+}
+
+func (this *Node) isClosed() bool {
+	select {
+	case <-this.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (this *Node) closeTransport() {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+	})
+}
+
+func (this *Node) deliverAfter(d datagram, latency time.Duration) {
+	if latency <= 0 {
+		this.deliver(d)
+		return
+	}
+	time.AfterFunc(latency, func() {
+		this.deliver(d)
+	})
+}
+
+func (this *Node) deliver(d datagram) {
+	select {
+	case this.inbox <- d:
+	default:
+		//Full inbox loses the datagram, same as a real socket buffer
+	}
+}
+
+// nodeTransport is how the node's registry talks to the network
+type nodeTransport struct {
+	node *Node
+}
+
+func (this *nodeTransport) Send(data []byte) error {
+	if this.node.isClosed() {
+		return net.ErrClosed
+	}
+	this.node.network.send(this.node, data)
+	return nil
+}
+
+func (this *nodeTransport) Receive(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case d := <-this.node.inbox:
+		return copy(buf, d.data), d.from, nil
+	case <-this.node.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (this *nodeTransport) IsLocal(addr *net.UDPAddr) bool {
+	return addr != nil && addr.IP.Equal(this.node.Addr.IP)
+}
+
+func (this *nodeTransport) Close() error {
+	this.node.closeTransport()
+	return nil
+}
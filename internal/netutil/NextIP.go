@@ -0,0 +1,20 @@
+package netutil
+
+import "net"
+
+// NextIP is the address after ip, skipping ones ending in 0. It is how MemoryBus and apiregtest hand out addresses
+func NextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	//Skip .0 so nobody gets what looks like a network address
+	if next[len(next)-1] == 0 {
+		return NextIP(next)
+	}
+	return next
+}
@@ -0,0 +1,12 @@
+package netutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNextIPSkipsNetworkAddresses(t *testing.T) {
+	if !NextIP(net.IPv4(127, 0, 1, 255).To4()).Equal(net.IPv4(127, 0, 2, 1)) {
+		t.Fail()
+	}
+}
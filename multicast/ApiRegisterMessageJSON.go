@@ -1,11 +1,63 @@
 package multicast
 
-import "github.com/ZacharyDuve/apireg"
+import (
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+type messageType string
+
+const (
+	//Empty type is treated as register so older senders are still understood
+	registerMessage   messageType = "register"
+	unregisterMessage messageType = "unregister"
+	//Asks any other registry with the sender's instance ID to answer with a helloReply, carries no api
+	helloMessage      messageType = "hello"
+	helloReplyMessage messageType = "hello-reply"
+)
 
 type apiRegisterMessageJSON struct {
+	Type        messageType        `json:"type,omitempty"`
 	ApiName     string             `json:"api-name"`
 	ApiVersion  *versionJSON       `json:"api-version"`
 	ApiPort     int                `json:"api-port"`
 	SenderUUID  string             `json:"sender-uuid"`
 	Environment apireg.Environment `json:"env"`
+	//Address the sender wants clients to dial instead of where the packet came from. Empty means use the packet source
+	HostIP   string            `json:"host-ip,omitempty"`
+	Hostname string            `json:"hostname,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Protocol apireg.Protocol   `json:"protocol,omitempty"`
+	//How long the sender wants us to keep this registration without an update. Zero means use our own
+	LifeSpanMs int64 `json:"life-span-ms,omitempty"`
+	//Describes the sender's process, only sent with registers
+	Instance *instanceInfoJSON `json:"instance,omitempty"`
+	//Sender's incarnation, sent with every message so a late goodbye from before a restart can be spotted
+	Incarnation uint64 `json:"incarnation,omitempty"`
+}
+
+func (this *apiRegisterMessageJSON) isUnregister() bool {
+	return this.Type == unregisterMessage
+}
+
+func (this *apiRegisterMessageJSON) isHello() bool {
+	return this.Type == helloMessage || this.Type == helloReplyMessage
+}
+
+func (this *apiRegisterMessageJSON) lifeSpanOr(defaultLifeSpan time.Duration) time.Duration {
+	if this.LifeSpanMs <= 0 {
+		return defaultLifeSpan
+	}
+	return time.Duration(this.LifeSpanMs) * time.Millisecond
+}
+
+// instanceInfo is what the sender said about itself, zero for senders that don't say
+func (this *apiRegisterMessageJSON) instanceInfo() apireg.InstanceInfo {
+	var info apireg.InstanceInfo
+	if this.Instance != nil {
+		info = this.Instance.toInstanceInfo()
+	}
+	info.Incarnation = this.Incarnation
+	return info
 }
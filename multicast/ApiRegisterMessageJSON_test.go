@@ -0,0 +1,63 @@
+package multicast
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+func TestThatMessageWithoutLifeSpanUsesDefault(t *testing.T) {
+	m := &apiRegisterMessageJSON{}
+
+	if m.lifeSpanOr(time.Minute) != time.Minute {
+		t.Fail()
+	}
+}
+
+func TestThatMessageWithLifeSpanUsesSendersLifeSpan(t *testing.T) {
+	m := &apiRegisterMessageJSON{LifeSpanMs: 2500}
+
+	if m.lifeSpanOr(time.Minute) != time.Millisecond*2500 {
+		t.Fail()
+	}
+}
+
+func TestThatVersionJSONKeepsPreReleaseAndBuild(t *testing.T) {
+	v, _ := apireg.ParseVersion("v1.2.3-rc.1+abc123")
+
+	back, err := newVersionJSON(v).toVersion()
+	if err != nil || !back.Equal(v) {
+		t.Fail()
+	}
+}
+
+func TestThatMessageWithoutInstanceInfoHasZeroInstanceInfo(t *testing.T) {
+	m := &apiRegisterMessageJSON{}
+
+	if info := m.instanceInfo(); info.Hostname != "" || info.PID != 0 || !info.StartTime.IsZero() || info.Labels != nil {
+		t.Fail()
+	}
+}
+
+func TestThatInstanceInfoJSONRoundTrips(t *testing.T) {
+	info := apireg.InstanceInfo{Hostname: "smds-1", StartTime: time.Unix(1700000000, 0), PID: 42, GoVersion: "go1.22.6", BuildVersion: "v1.4.0", Labels: map[string]string{"board": "rev-c"}}
+	data, err := json.Marshal(newInstanceInfoJSON(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := &instanceInfoJSON{}
+	if err = json.Unmarshal(data, back); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &apiRegisterMessageJSON{Instance: back}
+	backInfo := m.instanceInfo()
+	if backInfo.Hostname != "smds-1" || !backInfo.StartTime.Equal(info.StartTime) || backInfo.PID != 42 || backInfo.GoVersion != "go1.22.6" || backInfo.BuildVersion != "v1.4.0" {
+		t.Error("lost info on the way", backInfo)
+	}
+	if value, _ := backInfo.LabelValue("board"); value != "rev-c" {
+		t.Fail()
+	}
+}
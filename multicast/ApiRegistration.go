@@ -6,13 +6,21 @@ import (
 	"time"
 
 	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
 )
 
 type apiRegistration struct {
-	api                 apireg.Api
-	timeRegistered      time.Time
-	timeRegisteredMutex sync.Mutex
-	lifeSpan            time.Duration
+	api            apireg.Api
+	timeRegistered time.Time
+	lifeSpan       time.Duration
+	//What the publishing instance last said about itself
+	instance apireg.InstanceInfo
+	//Instance that held the address before a restart with a new UUID and the incarnation it was on, so what it still
+	//has in flight can be told from it coming back
+	replacedID          uuid.UUID
+	replacedIncarnation uint64
+	//Guards everything above as it all changes when an update comes in
+	updateMutex sync.RWMutex
 }
 
 func newApiRegistration(api apireg.Api, timeReged time.Time, lifeSpan time.Duration) (*apiRegistration, error) {
@@ -24,21 +32,78 @@ func newApiRegistration(api apireg.Api, timeReged time.Time, lifeSpan time.Durat
 }
 
 func (this *apiRegistration) Api() apireg.Api {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
 	return this.api
 }
+
+// UpdateApi swaps in a newer copy of the same api, ex when its metadata changed
+func (this *apiRegistration) UpdateApi(a apireg.Api) {
+	this.updateMutex.Lock()
+	this.api = a
+	this.updateMutex.Unlock()
+}
+
+func (this *apiRegistration) Instance() apireg.InstanceInfo {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
+	return this.instance
+}
+
+func (this *apiRegistration) UpdateInstance(info apireg.InstanceInfo) {
+	this.updateMutex.Lock()
+	this.instance = info
+	this.updateMutex.Unlock()
+}
+
+// Replaced is the instance this registration was taken over from and its incarnation, uuid.Nil if there wasn't one
+func (this *apiRegistration) Replaced() (uuid.UUID, uint64) {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
+	return this.replacedID, this.replacedIncarnation
+}
+
+func (this *apiRegistration) UpdateReplaced(id uuid.UUID, incarnation uint64) {
+	this.updateMutex.Lock()
+	this.replacedID = id
+	this.replacedIncarnation = incarnation
+	this.updateMutex.Unlock()
+}
+
 func (this *apiRegistration) TimeRegistered() time.Time {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
 	return this.timeRegistered
 }
 
 func (this *apiRegistration) UpdateTimeRegistered(newTime time.Time) {
-	this.timeRegisteredMutex.Lock()
+	this.updateMutex.Lock()
+	this.timeRegistered = newTime
+	this.updateMutex.Unlock()
+}
+
+// Refresh records a new update for the registration along with the life span the sender asked for
+func (this *apiRegistration) Refresh(newTime time.Time, lifeSpan time.Duration) {
+	this.updateMutex.Lock()
 	this.timeRegistered = newTime
-	this.timeRegisteredMutex.Unlock()
+	this.lifeSpan = lifeSpan
+	this.updateMutex.Unlock()
 }
 
 func (this *apiRegistration) LifeSpan() time.Duration {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
 	return this.lifeSpan
 }
+
+// Deadline is when the registration expires if nothing else is heard for it
+func (this *apiRegistration) Deadline() time.Time {
+	this.updateMutex.RLock()
+	defer this.updateMutex.RUnlock()
+	return this.timeRegistered.Add(this.lifeSpan)
+}
+
+// Expired is true from the deadline on, the same moment the store's expiry loop removes it
 func (this *apiRegistration) Expired(otherTime time.Time) bool {
-	return this.timeRegistered.Add(this.lifeSpan).Before(otherTime)
+	return !this.Deadline().After(otherTime)
 }
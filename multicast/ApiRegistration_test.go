@@ -65,3 +65,24 @@ func getValidApi() apireg.Api {
 	api, _ := apireg.NewApi("someApi", apireg.NewVersion(0, 0, 0), uuid.New(), apireg.All, net.IPv4(192, 168, 0, 3), 8080)
 	return api
 }
+
+func TestThatRefreshUpdatesTimeAndLifeSpan(t *testing.T) {
+	now := time.Now()
+	reg, _ := newApiRegistration(getValidApi(), now, time.Second*30)
+	later := now.Add(time.Second * 10)
+	reg.Refresh(later, time.Second*5)
+
+	if !reg.TimeRegistered().Equal(later) || reg.LifeSpan() != time.Second*5 {
+		t.Fail()
+	}
+}
+
+func TestThatRegistrationIsExpiredRightAtItsDeadline(t *testing.T) {
+	now := time.Now()
+	life := time.Second * 30
+	reg, _ := newApiRegistration(getValidApi(), now, life)
+
+	if !reg.Expired(now.Add(life)) || reg.Expired(now.Add(life-time.Nanosecond)) {
+		t.Fail()
+	}
+}
@@ -2,11 +2,13 @@ package multicast
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/ZacharyDuve/apireg"
@@ -15,13 +17,15 @@ import (
 
 const (
 	DEFAULT_MULTICAST_GROUP_IP   string        = "224.0.0.78"
+	DEFAULT_MULTICAST_GROUP_IPV6 string        = "ff02::4e"
 	DEFAULT_MULTICAST_GROUP_PORT int           = 5324
 	registrationMessageSizeBytes int           = 1400
 	registrationLifeSpan         time.Duration = registrationUpdateInterval * 4
 	registrationUpdateInterval   time.Duration = time.Second * 15
-	registrationPurgeInterval    time.Duration = time.Second * 30
 )
 
+var errRegistryClosed = errors.New("registry has been closed")
+
 type ownedApi struct {
 	name    string
 	version apireg.Version
@@ -29,50 +33,124 @@ type ownedApi struct {
 }
 
 type multicastApiRegistry struct {
-	mAddr *net.UDPAddr
-	mConn *net.UDPConn
+	transport Transport
+	cfg       *config
+	logger    *log.Logger
 	//Need to save all of the apis that have been registered externally
 	apiRegs *syncApiRegStore
 	//Need to know which api registrations are ours so that due to multicast we can double check
-	ownedApis          *syncApiStore
-	purgeExpiredTicker *time.Ticker
-	id                 uuid.UUID
-	environment        apireg.Environment
+	ownedApis    *syncApiStore
+	updateTicker Ticker
+	clock        Clock
+	id           uuid.UUID
+	//Sent with every register so peers know what we are
+	instanceInfo apireg.InstanceInfo
+	environment  apireg.Environment
+	//Incarnations of other registries using our instance ID that have been reported, only used by the listen loop
+	reportedDuplicates map[uint64]bool
+	//Gets the first duplicate found for the startup check
+	duplicateFound chan error
+	//Closed when the registry is shutting down so background loops know to exit
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-func NewMulticastRegistry(lAddr *net.UDPAddr, e apireg.Environment, sId uuid.UUID) (apireg.ApiRegistry, error) {
-	//If we are not passed in a lAddr then lets set to defaults
-	if lAddr == nil {
-		lAddr = &net.UDPAddr{IP: net.ParseIP(DEFAULT_MULTICAST_GROUP_IP), Port: DEFAULT_MULTICAST_GROUP_PORT}
+func NewMulticastRegistry(lAddr *net.UDPAddr, e apireg.Environment, sId uuid.UUID, opts ...Option) (apireg.ApiRegistry, error) {
+	cfg := newDefaultConfig()
+	for _, curOpt := range opts {
+		if err := curOpt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	transport := cfg.transport
+	if transport == nil {
+		var err error
+		transport, err = defaultTransport(lAddr, cfg)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	r := &multicastApiRegistry{}
-	r.purgeExpiredTicker = time.NewTicker(registrationPurgeInterval)
-	r.apiRegs = newSyncApiRegistrationStore(r.purgeExpiredTicker.C)
+	r.cfg = cfg
+	r.logger = cfg.logger
+	r.clock = cfg.clock
+	r.apiRegs = newSyncApiRegistrationStoreWithListeners(newSyncRegistrationListenerStoreWithPolicy(cfg.listenerBufferSize, cfg.overflowPolicy, cfg.logger), cfg.clock)
 	r.id = sId
+	r.instanceInfo = localInstanceInfo(cfg)
 	r.environment = e
-	r.mAddr = lAddr
-
+	r.transport = transport
+	r.closed = make(chan struct{})
 	r.ownedApis = newSyncApiStore()
+	r.updateTicker = cfg.clock.NewTicker(cfg.updateInterval)
+	r.reportedDuplicates = make(map[uint64]bool)
+	r.duplicateFound = make(chan error, 1)
 
-	mC, err := net.ListenMulticastUDP("udp", nil, lAddr)
+	go r.listenMutlicast()
+	go r.resendOwnedRegistrationsLoop()
+
+	if cfg.duplicateCheckWait > 0 {
+		if err := r.checkForDuplicate(cfg.duplicateCheckWait); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// defaultTransport is UDP multicast on lAddr, or the default group if lAddr is nil
+func defaultTransport(lAddr *net.UDPAddr, cfg *config) (Transport, error) {
+	if lAddr == nil {
+		groupIP := DEFAULT_MULTICAST_GROUP_IP
+		if cfg.ipv6 {
+			groupIP = DEFAULT_MULTICAST_GROUP_IPV6
+		}
+		lAddr = &net.UDPAddr{IP: net.ParseIP(groupIP), Port: DEFAULT_MULTICAST_GROUP_PORT}
+	} else if lAddr.IP.To4() == nil {
+		//We were handed an IPv6 group so run in IPv6 mode even without WithIPv6
+		cfg.ipv6 = true
+	}
+
+	ifaces, err := cfg.interfacesToUse()
 
 	if err != nil {
 		return nil, err
 	}
-	r.mConn = mC
 
-	go r.listenMutlicast()
-	go r.resendOwnedRegistrationsLoop()
-	return r, nil
+	return newUDPTransport(lAddr, cfg, ifaces)
+}
+
+func (this *multicastApiRegistry) isClosed() bool {
+	select {
+	case <-this.closed:
+		return true
+	default:
+		return false
+	}
 }
 
-func (this *multicastApiRegistry) RegisterApi(name string, version apireg.Version, port int) error {
+func (this *multicastApiRegistry) RegisterApi(name string, version apireg.Version, port int, opts ...apireg.ApiOption) error {
+	if this.isClosed() {
+		return errRegistryClosed
+	}
 	if name == "" {
 		return errors.New("name was empty and name is a required parameter")
 	}
-	//We just set a bogus ip as listeners don't get this ip but from the actual packet
-	localApi, err := apireg.NewApi(name, version, this.id, this.environment, net.ParseIP("0.0.0.0"), port)
+	//Registry wide settings go first so the ones for just this registration win
+	allOpts := make([]apireg.ApiOption, 0, len(opts)+2)
+	if this.cfg.advertisedIP != nil {
+		allOpts = append(allOpts, apireg.WithHostIP(this.cfg.advertisedIP))
+	}
+	if this.cfg.advertisedHostname != "" {
+		allOpts = append(allOpts, apireg.WithHostname(this.cfg.advertisedHostname))
+	}
+	allOpts = append(allOpts, opts...)
+	//Unless told otherwise we set an unspecified ip so listeners use the ip from the actual packet
+	localApi, err := apireg.NewApi(name, version, this.id, this.environment, net.IPv4zero, port, allOpts...)
 
 	if err != nil {
 		return err
@@ -82,58 +160,116 @@ func (this *multicastApiRegistry) RegisterApi(name string, version apireg.Versio
 		return nil
 	}
 
-	err = this.sendApiRegistration(localApi)
+	//Ours before it is sent so it doesn't look like someone else's when it comes back to us. Replaces one registered
+	//earlier for the same name, version and port with different metadata or protocol
+	prev := this.ownedApis.Add(localApi)
+	err = this.sendApiMessage(localApi, registerMessage)
 
-	if err == nil {
-		this.ownedApis.Add(localApi)
+	if err != nil {
+		if prev != nil {
+			this.ownedApis.Add(prev)
+		} else {
+			this.ownedApis.Remove(localApi)
+		}
 	}
 	return err
 }
 
-func (this *multicastApiRegistry) sendApiRegistration(a apireg.Api) error {
-	conn, err := net.DialUDP("udp", nil, this.mAddr)
-
-	if err != nil {
-		return err
+func (this *multicastApiRegistry) UnregisterApi(name string, version apireg.Version, port int) error {
+	if this.isClosed() {
+		return errRegistryClosed
+	}
+	if version == nil {
+		return errors.New("version is required for UnregisterApi")
+	}
+	//Match on what the caller knows about, the registration may also have an advertised ip or hostname. There is one
+	//for each advertised ip it was registered with so take them all down
+	var firstErr error
+	removedAny := false
+	for _, curOwnedApi := range this.ownedApis.All() {
+		if curOwnedApi.Name() == name && curOwnedApi.Version().Equal(version) && curOwnedApi.HostPort() == port && this.ownedApis.Remove(curOwnedApi) {
+			removedAny = true
+			if err := this.sendApiMessage(curOwnedApi, unregisterMessage); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	//If it isn't ours then there is nothing for us to take down
+	if !removedAny {
+		return errors.New(fmt.Sprint("no registered api for ", name, " ", version, " on port ", port))
 	}
+	return firstErr
+}
 
+func (this *multicastApiRegistry) sendApiMessage(a apireg.Api, mType messageType) error {
 	message := &apiRegisterMessageJSON{
+		Type:        mType,
 		ApiName:     a.Name(),
-		ApiVersion:  &versionJSON{Major: a.Version().Major(), Minor: a.Version().Minor(), BugFix: a.Version().BugFix()},
+		ApiVersion:  newVersionJSON(a.Version()),
 		ApiPort:     a.HostPort(),
 		SenderUUID:  this.id.String(),
-		Environment: this.environment}
+		Environment: this.environment,
+		Hostname:    a.Hostname(),
+		Metadata:    a.Metadata(),
+		Protocol:    a.Protocol(),
+		LifeSpanMs:  this.cfg.lifeSpan.Milliseconds(),
+		Incarnation: this.instanceInfo.Incarnation}
+
+	if mType == registerMessage {
+		message.Instance = newInstanceInfoJSON(this.instanceInfo)
+	}
+
+	if !a.HostIP().IsUnspecified() {
+		message.HostIP = a.HostIP().String()
+	}
 
-	dataOut := bytes.NewBuffer(make([]byte, 0, registrationMessageSizeBytes))
+	dataOut := bytes.NewBuffer(make([]byte, 0, this.cfg.messageSizeBytes))
 
-	err = json.NewEncoder(dataOut).Encode(message)
+	err := json.NewEncoder(dataOut).Encode(message)
 
 	if err != nil {
 		return err
 	}
 
-	if dataOut.Len() > registrationMessageSizeBytes {
-		return errors.New(fmt.Sprint("Message size for", a.Name(), a.Version(), "exceeds max length of", registrationMessageSizeBytes, "bytes"))
+	if dataOut.Len() > this.cfg.messageSizeBytes {
+		return errors.New(fmt.Sprint("Message size for", a.Name(), a.Version(), "exceeds max length of", this.cfg.messageSizeBytes, "bytes"))
 	}
 
-	_, err = conn.Write(dataOut.Bytes())
-
-	return err
+	return this.transport.Send(dataOut.Bytes())
 }
 
 func (this *multicastApiRegistry) resendOwnedRegistrationsLoop() {
-	updateTicker := time.NewTicker(registrationUpdateInterval)
-	for range updateTicker.C {
-		this.processRegResends()
+	for {
+		select {
+		case <-this.updateTicker.C():
+			this.processRegResends()
+		case <-this.closed:
+			return
+		}
 	}
 }
 
 func (this *multicastApiRegistry) processRegResends() {
 	for _, curOwnedApi := range this.ownedApis.All() {
-		this.sendApiRegistration(curOwnedApi)
+		this.sendApiMessage(curOwnedApi, registerMessage)
 	}
 }
 
+func (this *multicastApiRegistry) Close() error {
+	var err error
+	this.closeOnce.Do(func() {
+		//Say goodbye first while the listeners on the other side are still around to hear it
+		for _, curOwnedApi := range this.ownedApis.All() {
+			this.sendApiMessage(curOwnedApi, unregisterMessage)
+		}
+		close(this.closed)
+		this.updateTicker.Stop()
+		this.apiRegs.Close()
+		err = this.transport.Close()
+	})
+	return err
+}
+
 func (this *multicastApiRegistry) GetAvailableApis() []apireg.Api {
 	allRegs := this.apiRegs.GetAllRegs()
 	allApis := make([]apireg.Api, len(allRegs))
@@ -154,6 +290,80 @@ func (this *multicastApiRegistry) GetApisByApiName(name string) []apireg.Api {
 	return apis
 }
 
+func (this *multicastApiRegistry) GetApisMatching(name string, constraint string) ([]apireg.Api, error) {
+	c, err := apireg.ParseConstraint(constraint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	apis := make([]apireg.Api, 0)
+	for _, curApi := range this.GetApisByApiName(name) {
+		if c.Check(curApi.Version()) {
+			apis = append(apis, curApi)
+		}
+	}
+	return apis, nil
+}
+
+func (this *multicastApiRegistry) Find(q apireg.Query) []apireg.Api {
+	var regs []*apiRegistration
+	//Only look at the one name if we can, saves going through everything
+	if q.Name != "" {
+		regs = this.apiRegs.GetAllRegsForName(q.Name)
+	} else {
+		regs = this.apiRegs.GetAllRegs()
+	}
+
+	apis := make([]apireg.Api, 0, len(regs))
+	for _, curReg := range regs {
+		curApi := curReg.Api()
+		if q.Matches(curApi) {
+			apis = append(apis, curApi)
+		}
+	}
+	apireg.SortApis(apis)
+	return apis
+}
+
+func (this *multicastApiRegistry) GetApisByMetadata(key, value string) []apireg.Api {
+	apis := make([]apireg.Api, 0)
+	for _, curReg := range this.apiRegs.GetAllRegs() {
+		if curValue, contains := curReg.Api().MetadataValue(key); contains && curValue == value {
+			apis = append(apis, curReg.Api())
+		}
+	}
+	return apis
+}
+
+func (this *multicastApiRegistry) GetApisByInstance(id uuid.UUID) []apireg.Api {
+	if id == uuid.Nil {
+		return make([]apireg.Api, 0)
+	}
+	return this.Find(apireg.Query{Instance: id})
+}
+
+func (this *multicastApiRegistry) Instances() []apireg.Instance {
+	regs := this.apiRegs.GetAllRegs()
+	apis := make([]apireg.Api, len(regs))
+	//Each api carries what its instance said about itself at the time, the latest one heard is the one to trust
+	newestRegs := make(map[uuid.UUID]*apiRegistration)
+	for i, curReg := range regs {
+		apis[i] = curReg.Api()
+		id := apis[i].UUID()
+		if newest, contains := newestRegs[id]; !contains || curReg.TimeRegistered().After(newest.TimeRegistered()) {
+			newestRegs[id] = curReg
+		}
+	}
+
+	instances := apireg.GroupByInstance(apis)
+	for i := range instances {
+		instances[i].InstanceInfo = newestRegs[instances[i].ID].Instance()
+		instances[i].Labels = copyLabels(instances[i].Labels)
+	}
+	return instances
+}
+
 func (this *multicastApiRegistry) AddEventListener(l apireg.RegistrationListener) {
 	this.apiRegs.AddListener(l)
 }
@@ -162,36 +372,129 @@ func (this *multicastApiRegistry) RemoveEventListener(l apireg.RegistrationListe
 	this.apiRegs.RemoveListener(l)
 }
 
+func (this *multicastApiRegistry) Watch(ctx context.Context, q apireg.Query) (<-chan apireg.RegistrationEvent, error) {
+	if this.isClosed() {
+		return nil, errRegistryClosed
+	}
+	w := newWatcher(q, watchBufferSize)
+	this.AddEventListener(w)
+
+	go this.closeWatcherWhenDone(ctx, w)
+	return w.events, nil
+}
+
+func (this *multicastApiRegistry) Subscribe(ctx context.Context, q apireg.Query) (apireg.Snapshot, <-chan apireg.RegistrationEvent, error) {
+	if this.isClosed() {
+		return apireg.Snapshot{}, nil, errRegistryClosed
+	}
+	var w *watcher
+	regs, revision := this.apiRegs.Subscribe(func(revision uint64) apireg.RegistrationListener {
+		w = newWatcher(q, watchBufferSize)
+		w.afterRevision = revision
+		return w
+	})
+
+	snapshot := apireg.Snapshot{Revision: revision, Apis: make([]apireg.Api, 0, len(regs))}
+	for _, curReg := range regs {
+		curApi := curReg.Api()
+		if q.Matches(curApi) {
+			snapshot.Apis = append(snapshot.Apis, curApi)
+		}
+	}
+	apireg.SortApis(snapshot.Apis)
+
+	go this.closeWatcherWhenDone(ctx, w)
+	return snapshot, w.events, nil
+}
+
+func (this *multicastApiRegistry) closeWatcherWhenDone(ctx context.Context, w *watcher) {
+	select {
+	case <-ctx.Done():
+	case <-this.closed:
+	}
+	this.RemoveEventListener(w)
+	w.Close()
+}
+
 func (this *multicastApiRegistry) listenMutlicast() {
-	readBuff := make([]byte, registrationMessageSizeBytes)
+	readBuff := make([]byte, this.cfg.messageSizeBytes)
 	for {
-		nRead, rAddr, err := this.mConn.ReadFromUDP(readBuff)
+		nRead, rAddr, err := this.transport.Receive(readBuff)
 		if err != nil {
-			log.Println("Error during multicast read", err)
+			if this.isClosed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			this.logger.Println("Error during multicast read", err)
 		} else {
 			message := &apiRegisterMessageJSON{}
 			err = json.NewDecoder(bytes.NewReader(readBuff[0:nRead])).Decode(message)
 			if err != nil {
-				log.Println("Error decoding multicast json", err)
+				this.logger.Println("Error decoding multicast json", err)
 			} else {
-				ourIDAsString := this.id.String()
-				//If we got a message from ourselves or for another environment then ignore it
-				if message.SenderUUID == ourIDAsString || !shouldProcessMessage(this.environment, message.Environment) {
+				if message.SenderUUID == this.id.String() {
+					//Hearing ourselves is normal with loopback, another registry with our ID isn't and its apis are still real
+					if !this.isFromDuplicate(message, rAddr) {
+						continue
+					}
+					this.reportDuplicate(message, rAddr)
+					if message.Type == helloMessage {
+						this.sendHello(helloReplyMessage)
+					}
+				}
+				//Hellos have no api and messages for another environment aren't for us
+				if message.isHello() || !shouldProcessMessage(this.environment, message.Environment) {
 					continue
 				}
 				var a apireg.Api
-				apiVersion := apireg.NewVersion(message.ApiVersion.Major, message.ApiVersion.Minor, message.ApiVersion.BugFix)
-				a, err = apireg.NewApi(message.ApiName, apiVersion, this.id, message.Environment, rAddr.IP, message.ApiPort)
+				a, err = this.apiFromMessage(message, rAddr)
 				if err != nil {
-					log.Println("Error generating new Api from message")
+					this.logger.Println("Error generating new Api from message", err)
+				} else if message.isUnregister() {
+					this.apiRegs.RemoveRegForApi(a, message.Incarnation)
 				} else {
-					this.updateForApi(a)
+					this.updateForApi(a, message.instanceInfo(), message.lifeSpanOr(this.cfg.lifeSpan))
 				}
 			}
 		}
 	}
 }
 
+func (this *multicastApiRegistry) apiFromMessage(message *apiRegisterMessageJSON, rAddr *net.UDPAddr) (apireg.Api, error) {
+	if message.ApiVersion == nil {
+		return nil, errors.New("message is missing api-version")
+	}
+	apiVersion, err := message.ApiVersion.toVersion()
+
+	if err != nil {
+		return nil, err
+	}
+
+	senderID, err := uuid.Parse(message.SenderUUID)
+	if err != nil || senderID == uuid.Nil {
+		return nil, errors.New(fmt.Sprint("message has invalid sender-uuid ", message.SenderUUID))
+	}
+
+	//Prefer the address the sender asked us to use over where the packet came from
+	hostIP := rAddr.IP
+	if message.HostIP != "" {
+		hostIP = net.ParseIP(message.HostIP)
+		if hostIP == nil {
+			return nil, errors.New(fmt.Sprint("message has invalid host-ip ", message.HostIP))
+		}
+	}
+	//Zones are local to us so only the link we heard it on makes sense, and only for link-local addresses
+	var zone string
+	if hostIP.IsLinkLocalUnicast() {
+		zone = rAddr.Zone
+	}
+
+	return apireg.NewApi(message.ApiName, apiVersion, senderID, message.Environment, hostIP, message.ApiPort,
+		apireg.WithHostZone(zone),
+		apireg.WithHostname(message.Hostname),
+		apireg.WithMetadata(message.Metadata),
+		apireg.WithProtocol(message.Protocol))
+}
+
 //Us	| Msg	| pro
 // A	| A		| Y
 // A	| P		| Y
@@ -207,24 +510,6 @@ func shouldProcessMessage(ourEnv, otherEnv apireg.Environment) bool {
 	return ourEnv == apireg.All || otherEnv == apireg.All || ourEnv == otherEnv
 }
 
-func (this *multicastApiRegistry) updateForApi(a apireg.Api) {
-	apisForName := this.apiRegs.GetAllRegsForName(a.Name())
-
-	if len(apisForName) == 0 {
-		reg, _ := newApiRegistration(a, time.Now(), registrationLifeSpan)
-		this.apiRegs.AddReg(reg)
-	} else if len(apisForName) > 0 {
-		matched := false
-		for _, curReg := range apisForName {
-			if curReg.Api().Equal(a) {
-				curReg.UpdateTimeRegistered(time.Now())
-				matched = true
-			}
-		}
-
-		if !matched {
-			reg, _ := newApiRegistration(a, time.Now(), registrationLifeSpan)
-			this.apiRegs.AddReg(reg)
-		}
-	}
+func (this *multicastApiRegistry) updateForApi(a apireg.Api, instance apireg.InstanceInfo, lifeSpan time.Duration) {
+	this.apiRegs.PutApi(a, instance, this.clock.Now(), lifeSpan)
 }
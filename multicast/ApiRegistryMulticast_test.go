@@ -1,7 +1,11 @@
 package multicast
 
 import (
+	"context"
 	"log"
+	"net"
+	"os"
+	"runtime"
 	"testing"
 	"time"
 
@@ -53,3 +57,563 @@ func failOnErr(err error, t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestThatTwoRegistriesOnAMemoryBusRegisterEachOther(t *testing.T) {
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus)
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	reg0.RegisterApi("Something", apireg.NewVersion(0, 1, 3), 8080)
+	reg1.RegisterApi("Other", apireg.NewVersion(0, 1, 3), 8080)
+
+	if !waitFor(func() bool {
+		return len(reg0.GetApisByApiName("Other")) == 1 && len(reg1.GetApisByApiName("Something")) == 1
+	}) {
+		t.Fatal("registries never saw each other")
+	}
+	//Each member of the bus looks like its own host
+	if reg0.GetApisByApiName("Other")[0].HostIP().Equal(reg1.GetApisByApiName("Something")[0].HostIP()) {
+		t.Fail()
+	}
+	//Nor should either see its own api as a peer's
+	if len(reg0.GetApisByApiName("Something")) != 0 {
+		t.Fail()
+	}
+}
+
+func newBusRegistry(t *testing.T, bus *MemoryBus, opts ...Option) apireg.ApiRegistry {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), append([]Option{WithTransport(bus.Join(nil))}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return cond()
+}
+
+func TestThatClosingARegistryRemovesItsApisFromPeers(t *testing.T) {
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus)
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	apiName := "Leaving"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8081)
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName(apiName)) == 1 }) {
+		t.Fatal("expected reg1 to know about reg0's api before close")
+	}
+
+	failOnErr(reg0.Close(), t)
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName(apiName)) == 0 }) {
+		t.Fail()
+	}
+}
+
+func TestThatRegisterApiAfterCloseReturnsError(t *testing.T) {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New())
+	failOnErr(err, t)
+	r.Close()
+
+	if r.RegisterApi("Something", apireg.NewVersion(0, 0, 1), 80) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatClosingTwiceDoesNotPanic(t *testing.T) {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New())
+	failOnErr(err, t)
+	r.Close()
+	r.Close()
+}
+
+func TestThatUnregisteringAnApiRemovesItFromPeers(t *testing.T) {
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus)
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	keptName := "Staying"
+	goneName := "Going"
+	v := apireg.NewVersion(1, 0, 0)
+	reg0.RegisterApi(keptName, v, 8082)
+	reg0.RegisterApi(goneName, v, 8083)
+	if !waitFor(func() bool { return len(reg1.GetAvailableApis()) == 2 }) {
+		t.Fatal("expected both apis to arrive")
+	}
+
+	failOnErr(reg0.UnregisterApi(goneName, v, 8083), t)
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName(goneName)) == 0 }) {
+		t.Fail()
+	}
+	if len(reg1.GetApisByApiName(keptName)) != 1 {
+		t.Fail()
+	}
+}
+
+func TestThatRegisteringAgainWithChangedMetadataReplacesTheRegistration(t *testing.T) {
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus, WithUpdateInterval(time.Millisecond*10), WithLifeSpan(time.Second))
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := reg1.Watch(ctx, apireg.Query{Name: "Changing"})
+	failOnErr(err, t)
+
+	v := apireg.NewVersion(1, 0, 0)
+	reg0.RegisterApi("Changing", v, 8000, apireg.WithMetadata(map[string]string{"build": "abc"}))
+	reg0.RegisterApi("Changing", v, 8000, apireg.WithMetadata(map[string]string{"build": "def"}))
+	if len(reg0.(*multicastApiRegistry).ownedApis.All()) != 1 {
+		t.Fatal("expected the second register to replace the first")
+	}
+	if !waitFor(func() bool {
+		apis := reg1.GetApisByApiName("Changing")
+		value := ""
+		if len(apis) == 1 {
+			value, _ = apis[0].MetadataValue("build")
+		}
+		return value == "def"
+	}) {
+		t.Fatal("expected the new metadata to reach reg1")
+	}
+	//Several resends later it hasn't flipped back
+	time.Sleep(time.Millisecond * 100)
+	updates := 0
+	for len(events) > 0 {
+		if (<-events).Type() == apireg.Updated {
+			updates++
+		}
+	}
+	if updates > 1 {
+		t.Error("registration flipped between the two", updates, "times")
+	}
+
+	failOnErr(reg0.UnregisterApi("Changing", v, 8000), t)
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName("Changing")) == 0 }) || len(reg0.(*multicastApiRegistry).ownedApis.All()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatUnregisteringAnApiThatWasNeverRegisteredReturnsError(t *testing.T) {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New())
+	failOnErr(err, t)
+	defer r.Close()
+
+	if r.UnregisterApi("Never", apireg.NewVersion(0, 0, 1), 80) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatReceivedRegistrationsUseTheSendersLifeSpan(t *testing.T) {
+	senderLife := time.Second * 8
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus, WithUpdateInterval(time.Second*2), WithLifeSpan(senderLife))
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	apiName := "ShortLived"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8084)
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName(apiName)) == 1 }) {
+		t.Fatal("expected the api to arrive")
+	}
+
+	regs := reg1.(*multicastApiRegistry).apiRegs.GetAllRegsForName(apiName)
+	if len(regs) != 1 || regs[0].LifeSpan() != senderLife {
+		t.Fail()
+	}
+}
+
+func TestThatRegistryOnASpecificInterfaceSeesPeers(t *testing.T) {
+	iface := getUpMulticastInterface()
+	if iface == nil {
+		t.Skip("no multicast capable interface that is up")
+	}
+	reg0, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithInterface(iface))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg0.Close()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithInterface(iface))
+	failOnErr(err, t)
+	defer reg1.Close()
+
+	apiName := "OnInterface"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8085)
+	time.Sleep(time.Second * 1)
+
+	if len(reg1.GetApisByApiName(apiName)) != 1 {
+		t.Fail()
+	}
+}
+
+func getUpMulticastInterface() *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, curIface := range ifaces {
+		if curIface.Flags&net.FlagUp != 0 && curIface.Flags&net.FlagMulticast != 0 && curIface.Flags&net.FlagLoopback == 0 {
+			return &curIface
+		}
+	}
+	return nil
+}
+
+func TestThatIPv6RegistriesRegisterEachOther(t *testing.T) {
+	if getUpMulticastInterface() == nil {
+		t.Skip("no multicast capable interface that is up")
+	}
+	reg0, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithIPv6())
+	if err != nil {
+		t.Skip("IPv6 multicast not available", err)
+	}
+	defer reg0.Close()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithIPv6())
+	failOnErr(err, t)
+	defer reg1.Close()
+
+	apiName := "OverIPv6"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8086)
+	time.Sleep(time.Second * 1)
+
+	apis := reg1.GetApisByApiName(apiName)
+	if len(apis) == 0 {
+		t.Fatal("reg1 did not see reg0's api over IPv6")
+	}
+	if apis[0].HostIP().To4() != nil {
+		t.Fail()
+	}
+	//Link-local addresses can't be dialed without knowing which interface they are on
+	if apis[0].HostIP().IsLinkLocalUnicast() && apis[0].HostZone() == "" {
+		t.Fail()
+	}
+}
+
+func TestThatPeersUseTheAdvertisedAddressAndHostname(t *testing.T) {
+	advertisedIP := net.ParseIP("10.1.2.3")
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus, WithAdvertisedIP(advertisedIP), WithAdvertisedHostname("shed-2"))
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	registryWideName := "Advertised"
+	overriddenName := "Overridden"
+	overrideIP := net.ParseIP("10.9.9.9")
+	reg0.RegisterApi(registryWideName, apireg.NewVersion(1, 0, 0), 8087)
+	reg0.RegisterApi(overriddenName, apireg.NewVersion(1, 0, 0), 8088, apireg.WithHostIP(overrideIP))
+	if !waitFor(func() bool { return len(reg1.GetAvailableApis()) == 2 }) {
+		t.Fatal("expected both apis to arrive")
+	}
+
+	apis := reg1.GetApisByApiName(registryWideName)
+	if len(apis) != 1 || !apis[0].HostIP().Equal(advertisedIP) || apis[0].Hostname() != "shed-2" {
+		t.Fail()
+	}
+	apis = reg1.GetApisByApiName(overriddenName)
+	if len(apis) != 1 || !apis[0].HostIP().Equal(overrideIP) {
+		t.Fail()
+	}
+
+	//Unregister only needs to know what was passed to RegisterApi not what was advertised
+	failOnErr(reg0.UnregisterApi(overriddenName, apireg.NewVersion(1, 0, 0), 8088), t)
+}
+
+func TestThatApiFromMessageRejectsInvalidHostIP(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New()}
+	message := &apiRegisterMessageJSON{ApiName: "Bad", ApiVersion: &versionJSON{}, ApiPort: 80, HostIP: "not-an-ip"}
+
+	_, err := r.apiFromMessage(message, &net.UDPAddr{IP: net.ParseIP("192.168.0.3"), Port: 5324})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatApiFromMessageUsesPacketSourceWithoutHostIP(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New()}
+	message := &apiRegisterMessageJSON{ApiName: "Good", ApiVersion: &versionJSON{}, ApiPort: 80, SenderUUID: uuid.NewString()}
+	source := net.ParseIP("192.168.0.3")
+
+	a, err := r.apiFromMessage(message, &net.UDPAddr{IP: source, Port: 5324})
+	if err != nil || !a.HostIP().Equal(source) {
+		t.Fail()
+	}
+}
+
+func TestThatApiFromMessageUsesTheSendersUUID(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New()}
+	sender := uuid.New()
+	message := &apiRegisterMessageJSON{ApiName: "Good", ApiVersion: &versionJSON{}, ApiPort: 80, SenderUUID: sender.String()}
+
+	a, err := r.apiFromMessage(message, &net.UDPAddr{IP: net.ParseIP("192.168.0.3"), Port: 5324})
+	if err != nil || a.UUID() != sender {
+		t.Fail()
+	}
+}
+
+func TestThatApiFromMessageRejectsMissingOrInvalidSenderUUID(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New()}
+	for _, curSender := range []string{"", "not-a-uuid", uuid.Nil.String()} {
+		message := &apiRegisterMessageJSON{ApiName: "Bad", ApiVersion: &versionJSON{}, ApiPort: 80, SenderUUID: curSender}
+
+		if _, err := r.apiFromMessage(message, &net.UDPAddr{IP: net.ParseIP("192.168.0.3"), Port: 5324}); err == nil {
+			t.Error("accepted sender-uuid", curSender)
+		}
+	}
+}
+
+func TestThatApisCanBeFoundByTheInstanceThatPublishedThem(t *testing.T) {
+	bus := NewMemoryBus()
+	id0 := uuid.New()
+	reg0, err := NewMulticastRegistry(nil, apireg.All, id0, WithTransport(bus.Join(nil)))
+	failOnErr(err, t)
+	defer reg0.Close()
+	id1 := uuid.New()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, id1, WithTransport(bus.Join(nil)))
+	failOnErr(err, t)
+	defer reg1.Close()
+	observer := newBusRegistry(t, bus)
+	defer observer.Close()
+
+	reg0.RegisterApi("Zero", apireg.NewVersion(1, 0, 0), 8000)
+	reg0.RegisterApi("ZeroToo", apireg.NewVersion(1, 0, 0), 8001)
+	reg1.RegisterApi("One", apireg.NewVersion(1, 0, 0), 8000)
+	if !waitFor(func() bool { return len(observer.GetAvailableApis()) == 3 }) {
+		t.Fatal("expected all three apis to arrive")
+	}
+
+	apis := observer.GetApisByInstance(id0)
+	if len(apis) != 2 || apis[0].UUID() != id0 || apis[1].UUID() != id0 {
+		t.Fatal("expected both of reg0's apis")
+	}
+	if len(observer.GetApisByInstance(uuid.New())) != 0 || len(observer.GetApisByInstance(uuid.Nil)) != 0 {
+		t.Fail()
+	}
+
+	instances := observer.Instances()
+	if len(instances) != 2 {
+		t.Fatal("expected 2 instances, got", len(instances))
+	}
+	for _, curInstance := range instances {
+		if (curInstance.ID == id0 && len(curInstance.Apis) != 2) || (curInstance.ID == id1 && len(curInstance.Apis) != 1) {
+			t.Fail()
+		}
+	}
+}
+
+func TestThatInstanceInfoIsSentToPeers(t *testing.T) {
+	bus := NewMemoryBus()
+	id := uuid.New()
+	reg0, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithBuildVersion("v1.4.0"), WithInstanceLabels(map[string]string{"board": "rev-c"}))
+	failOnErr(err, t)
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	reg0.RegisterApi("Described", apireg.NewVersion(1, 0, 0), 8000)
+	if !waitFor(func() bool { return len(reg1.Instances()) == 1 }) {
+		t.Fatal("expected reg0's instance to arrive")
+	}
+
+	instance := reg1.Instances()[0]
+	hostname, _ := os.Hostname()
+	if instance.ID != id || instance.Hostname != hostname || instance.PID != os.Getpid() || instance.GoVersion != runtime.Version() {
+		t.Error("unexpected process info", instance.InstanceInfo)
+	}
+	if instance.BuildVersion != "v1.4.0" || !instance.StartTime.Equal(processStartTime) {
+		t.Error("unexpected build or start time", instance.InstanceInfo)
+	}
+	if value, _ := instance.LabelValue("board"); value != "rev-c" {
+		t.Fail()
+	}
+	//Labels handed out are a copy
+	instance.Labels["board"] = "changed"
+	if value, _ := reg1.Instances()[0].LabelValue("board"); value != "rev-c" {
+		t.Fail()
+	}
+}
+
+func TestThatPeersSeeARestartAsOneUpdated(t *testing.T) {
+	bus := NewMemoryBus()
+	observer := newBusRegistry(t, bus)
+	defer observer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := observer.Watch(ctx, apireg.Query{Name: "Restarting"})
+	failOnErr(err, t)
+
+	//Same address both times like a process restarting on the same host
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.2.1"), Port: DEFAULT_MULTICAST_GROUP_PORT}
+	first, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithTransport(bus.Join(addr)), WithIncarnation(1))
+	failOnErr(err, t)
+	first.RegisterApi("Restarting", apireg.NewVersion(1, 0, 0), 8000)
+	if !waitFor(func() bool { return len(observer.GetApisByApiName("Restarting")) == 1 }) {
+		t.Fatal("expected the first instance's api to arrive")
+	}
+	//Crashes without saying goodbye, with its transport gone first Close has nothing to send it on
+	first.(*multicastApiRegistry).transport.Close()
+	first.Close()
+
+	secondID := uuid.New()
+	second, err := NewMulticastRegistry(nil, apireg.All, secondID, WithTransport(bus.Join(addr)), WithIncarnation(2))
+	failOnErr(err, t)
+	defer second.Close()
+	second.RegisterApi("Restarting", apireg.NewVersion(1, 0, 0), 8000)
+
+	for _, want := range []apireg.EventType{apireg.Added, apireg.Updated} {
+		select {
+		case e := <-events:
+			if e.Type() != want {
+				t.Fatal("got", e.Type(), "want", want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("never got", want)
+		}
+	}
+	apis := observer.GetApisByApiName("Restarting")
+	if len(apis) != 1 || apis[0].UUID() != secondID {
+		t.Fail()
+	}
+}
+
+func TestThatMetadataAndProtocolAreSentToPeers(t *testing.T) {
+	bus := NewMemoryBus()
+	reg0 := newBusRegistry(t, bus)
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus)
+	defer reg1.Close()
+
+	apiName := "WithMetadata"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8089, apireg.WithMetadata(map[string]string{"scheme": "https", "zone": "shed-2"}), apireg.WithProtocol(apireg.HTTPS))
+	if !waitFor(func() bool { return len(reg1.GetApisByApiName(apiName)) == 1 }) {
+		t.Fatal("expected the api to arrive")
+	}
+
+	apis := reg1.GetApisByMetadata("zone", "shed-2")
+	if len(apis) != 1 || apis[0].Name() != apiName {
+		t.Fatal("expected to find api by its metadata")
+	}
+	if value, _ := apis[0].MetadataValue("scheme"); value != "https" {
+		t.Fail()
+	}
+	if apis[0].Protocol() != apireg.HTTPS {
+		t.Fail()
+	}
+}
+
+func TestThatUpdateForApiWithChangedMetadataReplacesRegistration(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}}
+	id := uuid.New()
+	ip := net.ParseIP("192.168.0.3")
+	a0, _ := apireg.NewApi("Changing", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"build": "abc"}))
+	a1, _ := apireg.NewApi("Changing", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"build": "def"}))
+
+	r.updateForApi(a0, apireg.InstanceInfo{}, time.Minute)
+	r.updateForApi(a1, apireg.InstanceInfo{}, time.Minute)
+
+	apis := r.GetApisByApiName("Changing")
+	if len(apis) != 1 {
+		t.Fatal("expected a single registration")
+	}
+	if value, _ := apis[0].MetadataValue("build"); value != "def" {
+		t.Fail()
+	}
+}
+
+func TestThatGetApisMatchingFiltersByConstraint(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}}
+	for i, curVersion := range []string{"v1.2.0", "v1.3.0", "v1.9.4", "v2.0.0"} {
+		v, _ := apireg.ParseVersion(curVersion)
+		a, _ := apireg.NewApi("SMDS", v, uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 8000+i)
+		r.updateForApi(a, apireg.InstanceInfo{}, time.Minute)
+	}
+
+	apis, err := r.GetApisMatching("SMDS", "^1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apis) != 2 {
+		t.Fail()
+	}
+	for _, curApi := range apis {
+		if curApi.Version().Major() != 1 || curApi.Version().Minor() < 3 {
+			t.Fail()
+		}
+	}
+}
+
+func TestThatGetApisMatchingReturnsErrorForBadConstraint(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}}
+
+	if _, err := r.GetApisMatching("SMDS", ">=one"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestThatFindReturnsMatchingApisInOrder(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}}
+	for i, curName := range []string{"SMDS-B", "TCC", "SMDS-A"} {
+		a, _ := apireg.NewApi(curName, apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 8000+i)
+		r.updateForApi(a, apireg.InstanceInfo{}, time.Minute)
+	}
+
+	apis := r.Find(apireg.Query{NameGlob: "SMDS*"})
+	if len(apis) != 2 || apis[0].Name() != "SMDS-A" || apis[1].Name() != "SMDS-B" {
+		t.Fail()
+	}
+}
+
+func TestThatPeersResendAndExpireOnTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	bus := NewMemoryBus()
+	t0 := bus.Join(nil)
+	interval := time.Second * 15
+	life := time.Minute
+	reg0, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithTransport(t0), WithClock(clock), WithUpdateInterval(interval), WithLifeSpan(life))
+	failOnErr(err, t)
+	defer reg0.Close()
+	reg1 := newBusRegistry(t, bus, WithClock(clock))
+	defer reg1.Close()
+	l := &recordingListener{}
+	reg1.AddEventListener(l)
+	store1 := reg1.(*multicastApiRegistry).apiRegs
+
+	apiName := "Heartbeat"
+	reg0.RegisterApi(apiName, apireg.NewVersion(1, 0, 0), 8090)
+	if !waitForTimerAt(clock, time.Unix(1000, 0).Add(life)) {
+		t.Fatal("reg1 never scheduled the expiry")
+	}
+
+	//A resend pushes the deadline back by one interval without telling listeners anything
+	clock.Advance(interval)
+	if !waitForTimerAt(clock, time.Unix(1000, 0).Add(interval+life)) {
+		t.Fatal("resend never refreshed the registration")
+	}
+
+	//Pull the plug without a goodbye so the only way reg1 finds out is the life span running out
+	t0.Close()
+	clock.Advance(life - time.Nanosecond)
+	if len(store1.GetAllRegsForName(apiName)) != 1 {
+		t.Fatal("expired early")
+	}
+	clock.Advance(time.Nanosecond)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[0].Type() != apireg.Added || events[1].Type() != apireg.Expired {
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package multicast
+
+import "time"
+
+// Clock is where the registry gets the time, timers and tickers from. The default is the system clock, NewFakeClock
+// gives one that only moves when told to so tests can check expiry and resends without sleeping
+type Clock interface {
+	Now() time.Time
+	//NewTimer fires once after d
+	NewTimer(d time.Duration) Timer
+	//NewTicker fires every d until stopped, dropping ticks for slow readers like time.Ticker
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	//Stop returns false if the timer already fired or was stopped
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+func (this systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (this systemClock) NewTimer(d time.Duration) Timer {
+	return &systemTimer{time.NewTimer(d)}
+}
+
+func (this systemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (this *systemTimer) C() <-chan time.Time {
+	return this.timer.C
+}
+
+func (this *systemTimer) Stop() bool {
+	return this.timer.Stop()
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (this *systemTicker) C() <-chan time.Time {
+	return this.ticker.C
+}
+
+func (this *systemTicker) Stop() {
+	this.ticker.Stop()
+}
@@ -0,0 +1,119 @@
+package multicast
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateInstance is what every DuplicateInstanceError is, check for it with errors.Is
+var ErrDuplicateInstance = errors.New("another registry is using our instance ID")
+
+// DuplicateInstanceError is for a message with our instance ID that we didn't send, usually because two processes
+// were started with the same ID from a copied config. It gives itself away with a different incarnation, coming from
+// an address that isn't ours or being about an api we never registered
+type DuplicateInstanceError struct {
+	ID uuid.UUID
+	//Where the other registry's message came from
+	From *net.UDPAddr
+	//Incarnation of the other registry, 0 if it is too old to send one
+	Incarnation uint64
+}
+
+func (this *DuplicateInstanceError) Error() string {
+	return fmt.Sprint("another registry is using our instance ID ", this.ID, ", heard from ", this.From, " with incarnation ", this.Incarnation)
+}
+
+func (this *DuplicateInstanceError) Unwrap() error {
+	return ErrDuplicateInstance
+}
+
+// isFromDuplicate is true for a message with our ID that another registry sent. Only call it for messages with our ID
+func (this *multicastApiRegistry) isFromDuplicate(message *apiRegisterMessageJSON, rAddr *net.UDPAddr) bool {
+	if message.Incarnation != this.instanceInfo.Incarnation {
+		return true
+	}
+	//The same incarnation can still be someone else, ex a copied config with WithIncarnation or two registries in one process
+	if localTransport, ok := this.transport.(LocalTransport); ok && !localTransport.IsLocal(rAddr) {
+		return true
+	}
+	if message.isHello() {
+		return false
+	}
+	//We only ever send about apis we registered, and ones we let go of stay in ownedApis' history for late goodbyes
+	a, err := this.ownApiFromMessage(message)
+	return err != nil || !this.ownedApis.WasAdded(a)
+}
+
+// ownApiFromMessage is the api in message as it would be in ownedApis if we sent it
+func (this *multicastApiRegistry) ownApiFromMessage(message *apiRegisterMessageJSON) (apireg.Api, error) {
+	if message.ApiVersion == nil {
+		return nil, errors.New("message is missing api-version")
+	}
+	apiVersion, err := message.ApiVersion.toVersion()
+	if err != nil {
+		return nil, err
+	}
+	hostIP := net.IPv4zero
+	if message.HostIP != "" {
+		hostIP = net.ParseIP(message.HostIP)
+	}
+	return apireg.NewApi(message.ApiName, apiVersion, this.id, this.environment, hostIP, message.ApiPort)
+}
+
+// reportDuplicate tells the handler, or the log without one, about a registry using our ID. Only called from the
+// listen loop so reported needs no lock
+func (this *multicastApiRegistry) reportDuplicate(message *apiRegisterMessageJSON, rAddr *net.UDPAddr) {
+	//It keeps resending so only say something once for each one
+	if this.reportedDuplicates[message.Incarnation] {
+		return
+	}
+	this.reportedDuplicates[message.Incarnation] = true
+
+	err := &DuplicateInstanceError{ID: this.id, From: rAddr, Incarnation: message.Incarnation}
+	if this.cfg.duplicateInstanceHandler != nil {
+		this.cfg.duplicateInstanceHandler(err)
+	} else {
+		this.logger.Println(err)
+	}
+	select {
+	case this.duplicateFound <- err:
+	default:
+		//Only the startup check waits on it and it only needs the first one
+	}
+}
+
+// sendHello sends a hello or the reply to one. Neither carries an api, only who we are
+func (this *multicastApiRegistry) sendHello(mType messageType) error {
+	data, err := json.Marshal(&apiRegisterMessageJSON{
+		Type:        mType,
+		SenderUUID:  this.id.String(),
+		Environment: this.environment,
+		Incarnation: this.instanceInfo.Incarnation})
+
+	if err != nil {
+		return err
+	}
+	return this.transport.Send(data)
+}
+
+// checkForDuplicate says hello and waits up to wait for another registry with our ID to answer. Best effort as the
+// hello or the answer can be lost like any other datagram
+func (this *multicastApiRegistry) checkForDuplicate(wait time.Duration) error {
+	if err := this.sendHello(helloMessage); err != nil {
+		return err
+	}
+	timer := this.clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case err := <-this.duplicateFound:
+		return err
+	case <-timer.C():
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package multicast
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+type recordingErrorHandler struct {
+	errs  []error
+	mutex sync.Mutex
+}
+
+func (this *recordingErrorHandler) handle(err error) {
+	this.mutex.Lock()
+	this.errs = append(this.errs, err)
+	this.mutex.Unlock()
+}
+
+func (this *recordingErrorHandler) Errors() []error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]error(nil), this.errs...)
+}
+
+func TestThatARegistryWithOurIDIsReportedOnceAndItsApisAreKept(t *testing.T) {
+	bus := NewMemoryBus()
+	id := uuid.New()
+	handler := &recordingErrorHandler{}
+	reg0, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithIncarnation(1), WithDuplicateInstanceHandler(handler.handle))
+	failOnErr(err, t)
+	defer reg0.Close()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithIncarnation(2), WithUpdateInterval(time.Millisecond*10), WithLifeSpan(time.Second))
+	failOnErr(err, t)
+	defer reg1.Close()
+
+	reg1.RegisterApi("Copied", apireg.NewVersion(1, 0, 0), 8000)
+	if !waitFor(func() bool { return len(reg0.GetApisByApiName("Copied")) == 1 }) {
+		t.Fatal("expected reg0 to keep the copy's api")
+	}
+	//Let a few resends through to check it is only reported the once
+	time.Sleep(time.Millisecond * 50)
+
+	errs := handler.Errors()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrDuplicateInstance) {
+		t.Fatal("expected one duplicate instance error, got", errs)
+	}
+	var dupErr *DuplicateInstanceError
+	if !errors.As(errs[0], &dupErr) || dupErr.ID != id || dupErr.Incarnation != 2 {
+		t.Fail()
+	}
+}
+
+func TestThatARegistryWithOurIDAndIncarnationIsReportedFromItsAddress(t *testing.T) {
+	bus := NewMemoryBus()
+	id := uuid.New()
+	handler := &recordingErrorHandler{}
+	//Two registries in one process with no WithIncarnation share the default incarnation too
+	reg0, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithDuplicateInstanceHandler(handler.handle))
+	failOnErr(err, t)
+	defer reg0.Close()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)))
+	failOnErr(err, t)
+	defer reg1.Close()
+
+	reg1.RegisterApi("Copied", apireg.NewVersion(1, 0, 0), 8000)
+
+	if !waitFor(func() bool { return len(handler.Errors()) == 1 }) || len(reg0.GetApisByApiName("Copied")) != 1 {
+		t.Fatal("expected the copy to be reported and its api kept")
+	}
+}
+
+func TestThatARegistryWithOurIDIncarnationAndAddressIsReportedForApisWeDontOwn(t *testing.T) {
+	bus := NewMemoryBus()
+	id := uuid.New()
+	handler := &recordingErrorHandler{}
+	//Looks exactly like us on the wire apart from what it registers
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.2.1"), Port: DEFAULT_MULTICAST_GROUP_PORT}
+	reg0, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(addr)), WithIncarnation(7), WithDuplicateInstanceHandler(handler.handle))
+	failOnErr(err, t)
+	defer reg0.Close()
+	reg1, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(addr)), WithIncarnation(7))
+	failOnErr(err, t)
+	defer reg1.Close()
+
+	reg0.RegisterApi("Mine", apireg.NewVersion(1, 0, 0), 8000)
+	reg1.RegisterApi("Theirs", apireg.NewVersion(1, 0, 0), 8001)
+
+	if !waitFor(func() bool { return len(handler.Errors()) == 1 }) {
+		t.Fatal("expected the copy to be reported")
+	}
+	if len(reg0.GetApisByApiName("Theirs")) != 1 || len(reg0.GetApisByApiName("Mine")) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatOurOwnMessagesAreNotADuplicate(t *testing.T) {
+	bus := NewMemoryBus()
+	handler := &recordingErrorHandler{}
+	transport := bus.Join(nil)
+	reg, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithTransport(transport), WithDuplicateInstanceHandler(handler.handle))
+	failOnErr(err, t)
+	defer reg.Close()
+	//Something else on the bus so our own messages come back to us like with loopback
+	listener := newBusRegistry(t, bus)
+	defer listener.Close()
+
+	reg.RegisterApi("Mine", apireg.NewVersion(1, 0, 0), 8000)
+	reg.RegisterApi("Mine", apireg.NewVersion(1, 0, 0), 8000, apireg.WithMetadata(map[string]string{"build": "def"}))
+	reg.RegisterApi("Going", apireg.NewVersion(1, 0, 0), 8001)
+	reg.UnregisterApi("Going", apireg.NewVersion(1, 0, 0), 8001)
+	if !waitFor(func() bool { return len(listener.GetApisByApiName("Mine")) == 1 }) {
+		t.Fatal("expected the listener to hear our api")
+	}
+	//Let our own messages come back to us
+	time.Sleep(time.Millisecond * 20)
+
+	if len(handler.Errors()) != 0 || len(reg.GetAvailableApis()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatDuplicateInstanceCheckRefusesToStart(t *testing.T) {
+	bus := NewMemoryBus()
+	id := uuid.New()
+	handler := &recordingErrorHandler{}
+	running, err := NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithDuplicateInstanceHandler(handler.handle))
+	failOnErr(err, t)
+	defer running.Close()
+
+	_, err = NewMulticastRegistry(nil, apireg.All, id, WithTransport(bus.Join(nil)), WithIncarnation(1), WithDuplicateInstanceCheck(time.Second*5))
+	if !errors.Is(err, ErrDuplicateInstance) {
+		t.Fatal("expected to refuse to start, got", err)
+	}
+	//The one already running finds out too
+	if !waitFor(func() bool { return len(handler.Errors()) == 1 }) {
+		t.Fail()
+	}
+}
+
+func TestThatDuplicateInstanceCheckStartsWhenNobodyAnswers(t *testing.T) {
+	bus := NewMemoryBus()
+	other := newBusRegistry(t, bus)
+	defer other.Close()
+
+	reg, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithTransport(bus.Join(nil)), WithDuplicateInstanceCheck(time.Millisecond*20))
+	failOnErr(err, t)
+	reg.Close()
+}
@@ -0,0 +1,97 @@
+package multicast
+
+import (
+	"container/heap"
+	"time"
+)
+
+type expiryEntry struct {
+	reg      *apiRegistration
+	deadline time.Time
+	//Position in the heap so an entry can be moved or removed without searching for it
+	index int
+}
+
+// expiryQueue keeps registrations ordered by when they expire, soonest first. Not safe for concurrent use
+type expiryQueue struct {
+	entries []*expiryEntry
+	byReg   map[*apiRegistration]*expiryEntry
+}
+
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{entries: make([]*expiryEntry, 0), byReg: make(map[*apiRegistration]*expiryEntry)}
+}
+
+// Schedule sets when reg expires, replacing any deadline it already had. Returns true if reg is now the next to expire
+func (this *expiryQueue) Schedule(reg *apiRegistration, deadline time.Time) bool {
+	if e, contains := this.byReg[reg]; contains {
+		e.deadline = deadline
+		heap.Fix((*expiryHeap)(this), e.index)
+		return e.index == 0
+	}
+	e := &expiryEntry{reg: reg, deadline: deadline}
+	this.byReg[reg] = e
+	heap.Push((*expiryHeap)(this), e)
+	return e.index == 0
+}
+
+func (this *expiryQueue) Cancel(reg *apiRegistration) {
+	if e, contains := this.byReg[reg]; contains {
+		heap.Remove((*expiryHeap)(this), e.index)
+	}
+}
+
+// Next is the soonest deadline, false if nothing is scheduled
+func (this *expiryQueue) Next() (time.Time, bool) {
+	if len(this.entries) == 0 {
+		return time.Time{}, false
+	}
+	return this.entries[0].deadline, true
+}
+
+// PopDue removes and returns every registration with a deadline at or before t, soonest first
+func (this *expiryQueue) PopDue(t time.Time) []*apiRegistration {
+	due := make([]*apiRegistration, 0)
+	for len(this.entries) > 0 && !this.entries[0].deadline.After(t) {
+		e := heap.Pop((*expiryHeap)(this)).(*expiryEntry)
+		due = append(due, e.reg)
+	}
+	return due
+}
+
+func (this *expiryQueue) Len() int {
+	return len(this.entries)
+}
+
+// expiryHeap is the heap.Interface side of expiryQueue, kept separate so callers can't mess up the ordering
+type expiryHeap expiryQueue
+
+func (this *expiryHeap) Len() int {
+	return len(this.entries)
+}
+
+func (this *expiryHeap) Less(i, j int) bool {
+	return this.entries[i].deadline.Before(this.entries[j].deadline)
+}
+
+func (this *expiryHeap) Swap(i, j int) {
+	this.entries[i], this.entries[j] = this.entries[j], this.entries[i]
+	this.entries[i].index = i
+	this.entries[j].index = j
+}
+
+func (this *expiryHeap) Push(x any) {
+	e := x.(*expiryEntry)
+	e.index = len(this.entries)
+	this.entries = append(this.entries, e)
+}
+
+func (this *expiryHeap) Pop() any {
+	last := len(this.entries) - 1
+	e := this.entries[last]
+	this.entries[last] = nil
+	this.entries = this.entries[:last]
+	delete(this.byReg, e.reg)
+	e.index = -1
+	return e
+}
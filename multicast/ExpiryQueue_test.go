@@ -0,0 +1,57 @@
+package multicast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThatExpiryQueuePopsDueRegsSoonestFirst(t *testing.T) {
+	q := newExpiryQueue()
+	now := time.Now()
+	reg0 := getValidApiReg()
+	reg1 := getValidApiReg()
+	reg2 := getValidApiReg()
+	q.Schedule(reg0, now.Add(time.Second*2))
+	q.Schedule(reg1, now.Add(time.Second))
+	q.Schedule(reg2, now.Add(time.Minute))
+
+	due := q.PopDue(now.Add(time.Second * 3))
+	if len(due) != 2 || due[0] != reg1 || due[1] != reg0 || q.Len() != 1 {
+		t.Fail()
+	}
+}
+
+func TestThatRescheduleMovesARegInsteadOfAddingIt(t *testing.T) {
+	q := newExpiryQueue()
+	now := time.Now()
+	reg0 := getValidApiReg()
+	reg1 := getValidApiReg()
+	q.Schedule(reg0, now.Add(time.Second))
+	q.Schedule(reg1, now.Add(time.Second*2))
+
+	if !q.Schedule(reg1, now) || q.Len() != 2 {
+		t.Fail()
+	}
+	if q.Schedule(reg1, now.Add(time.Minute)) {
+		t.Fail()
+	}
+	if next, _ := q.Next(); !next.Equal(now.Add(time.Second)) {
+		t.Fail()
+	}
+}
+
+func TestThatCancelledRegIsNeverDue(t *testing.T) {
+	q := newExpiryQueue()
+	now := time.Now()
+	reg := getValidApiReg()
+	q.Schedule(reg, now)
+	q.Cancel(reg)
+	q.Cancel(reg)
+
+	if len(q.PopDue(now.Add(time.Hour))) != 0 || q.Len() != 0 {
+		t.Fail()
+	}
+	if _, scheduled := q.Next(); scheduled {
+		t.Fail()
+	}
+}
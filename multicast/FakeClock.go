@@ -0,0 +1,163 @@
+package multicast
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when Advance is called. Timers and tickers fire during Advance, in deadline
+// order, with Now set to the time they were due. Use BlockUntil before advancing to make sure whatever should be
+// waiting on the clock has started to
+type FakeClock struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	mutex   *sync.Mutex
+	//Signalled whenever waiters changes
+	waitersChanged *sync.Cond
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.waiters = make([]*fakeWaiter, 0)
+	c.mutex = &sync.Mutex{}
+	c.waitersChanged = sync.NewCond(c.mutex)
+	return c
+}
+
+func (this *FakeClock) Now() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.now
+}
+
+func (this *FakeClock) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{this.addWaiter(d, 0)}
+}
+
+func (this *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return &fakeTicker{this.addWaiter(d, d)}
+}
+
+func (this *FakeClock) addWaiter(d, period time.Duration) *fakeWaiter {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	//Buffered by one like time.Timer so firing never blocks Advance
+	w := &fakeWaiter{clock: this, c: make(chan time.Time, 1), deadline: this.now.Add(d), period: period}
+	if d <= 0 {
+		w.fire(this.now)
+		if period == 0 {
+			return w
+		}
+	}
+	this.waiters = append(this.waiters, w)
+	this.waitersChanged.Broadcast()
+	return w
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker that comes due along the way
+func (this *FakeClock) Advance(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	target := this.now.Add(d)
+
+	for {
+		next := this.nextDueLocked(target)
+		if next == nil {
+			break
+		}
+		this.now = next.deadline
+		next.fire(this.now)
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			this.removeLocked(next)
+		}
+	}
+	this.now = target
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting on the clock
+func (this *FakeClock) BlockUntil(n int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for len(this.waiters) < n {
+		this.waitersChanged.Wait()
+	}
+}
+
+// Waiters is how many timers and tickers are waiting on the clock right now
+func (this *FakeClock) Waiters() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.waiters)
+}
+
+func (this *FakeClock) nextDueLocked(target time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, curW := range this.waiters {
+		if !curW.deadline.After(target) && (next == nil || curW.deadline.Before(next.deadline)) {
+			next = curW
+		}
+	}
+	return next
+}
+
+func (this *FakeClock) removeLocked(w *fakeWaiter) bool {
+	for i, curW := range this.waiters {
+		if curW == w {
+			this.waiters = append(this.waiters[:i], this.waiters[i+1:]...)
+			this.waitersChanged.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// fakeWaiter is what is behind both timers and tickers for FakeClock, period is 0 for a timer
+type fakeWaiter struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (this *fakeWaiter) fire(t time.Time) {
+	select {
+	case this.c <- t:
+	default:
+		//Reader hasn't taken the last one yet, same as a real ticker we drop it
+	}
+}
+
+func (this *fakeWaiter) stop() bool {
+	this.clock.mutex.Lock()
+	defer this.clock.mutex.Unlock()
+	return this.clock.removeLocked(this)
+}
+
+type fakeTimer struct {
+	w *fakeWaiter
+}
+
+func (this *fakeTimer) C() <-chan time.Time {
+	return this.w.c
+}
+
+func (this *fakeTimer) Stop() bool {
+	return this.w.stop()
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (this *fakeTicker) C() <-chan time.Time {
+	return this.w.c
+}
+
+func (this *fakeTicker) Stop() {
+	this.w.stop()
+}
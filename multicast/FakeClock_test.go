@@ -0,0 +1,101 @@
+package multicast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThatFakeTimerOnlyFiresOnceItsTimeComes(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	timer := clock.NewTimer(time.Second * 10)
+
+	clock.Advance(time.Second * 9)
+	select {
+	case <-timer.C():
+		t.Fatal("fired early")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case fired := <-timer.C():
+		if !fired.Equal(time.Unix(1010, 0)) {
+			t.Fail()
+		}
+	default:
+		t.Fatal("never fired")
+	}
+	if clock.Waiters() != 0 || timer.Stop() {
+		t.Fail()
+	}
+}
+
+func TestThatStoppedFakeTimerNeverFires(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	timer := clock.NewTimer(time.Second)
+
+	if !timer.Stop() {
+		t.Fail()
+	}
+	clock.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Fail()
+	default:
+	}
+}
+
+func TestThatFakeTickerFiresEveryIntervalAndDropsTicksForSlowReaders(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	clock.Advance(time.Second * 3)
+	//Only room for one tick, like time.Ticker
+	if tick := <-ticker.C(); !tick.Equal(time.Unix(1001, 0)) {
+		t.Fail()
+	}
+	select {
+	case <-ticker.C():
+		t.Fail()
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if tick := <-ticker.C(); !tick.Equal(time.Unix(1004, 0)) {
+		t.Fail()
+	}
+}
+
+func TestThatAdvanceFiresTimersInDeadlineOrderWithNowAtEachDeadline(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	late := clock.NewTimer(time.Second * 2)
+	early := clock.NewTimer(time.Second)
+
+	clock.Advance(time.Second * 5)
+
+	if !(<-early.C()).Equal(time.Unix(1001, 0)) || !(<-late.C()).Equal(time.Unix(1002, 0)) || !clock.Now().Equal(time.Unix(1005, 0)) {
+		t.Fail()
+	}
+}
+
+func TestThatBlockUntilWaitsForTimers(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	done := make(chan struct{})
+	go func() {
+		clock.BlockUntil(2)
+		close(done)
+	}()
+
+	clock.NewTimer(time.Second)
+	select {
+	case <-done:
+		t.Fatal("returned with only one timer")
+	case <-time.After(time.Millisecond * 20):
+	}
+	clock.NewTicker(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+}
@@ -0,0 +1,78 @@
+package multicast
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+// processStartTime is as close as we can get to when the process started without asking the OS
+var processStartTime = time.Now()
+
+type instanceInfoJSON struct {
+	Hostname     string            `json:"hostname,omitempty"`
+	StartTime    time.Time         `json:"start-time"`
+	PID          int               `json:"pid,omitempty"`
+	GoVersion    string            `json:"go-version,omitempty"`
+	BuildVersion string            `json:"build-version,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+func newInstanceInfoJSON(info apireg.InstanceInfo) *instanceInfoJSON {
+	return &instanceInfoJSON{
+		Hostname:     info.Hostname,
+		StartTime:    info.StartTime,
+		PID:          info.PID,
+		GoVersion:    info.GoVersion,
+		BuildVersion: info.BuildVersion,
+		Labels:       info.Labels}
+}
+
+func (this *instanceInfoJSON) toInstanceInfo() apireg.InstanceInfo {
+	return apireg.InstanceInfo{
+		Hostname:     this.Hostname,
+		StartTime:    this.StartTime,
+		PID:          this.PID,
+		GoVersion:    this.GoVersion,
+		BuildVersion: this.BuildVersion,
+		Labels:       copyLabels(this.Labels)}
+}
+
+// localInstanceInfo describes this process, buildVersion, labels and incarnation from the options win over what we find ourselves
+func localInstanceInfo(cfg *config) apireg.InstanceInfo {
+	//Not knowing the hostname isn't worth failing over, peers still have the address
+	hostname, _ := os.Hostname()
+	buildVersion := cfg.buildVersion
+	if buildVersion == "" {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			buildVersion = buildInfo.Main.Version
+		}
+	}
+	incarnation := cfg.incarnation
+	if incarnation == 0 {
+		//A restarted process starts later so this goes up without having to remember anything between runs
+		incarnation = uint64(processStartTime.UnixNano())
+	}
+	return apireg.InstanceInfo{
+		Hostname:     hostname,
+		StartTime:    processStartTime,
+		PID:          os.Getpid(),
+		GoVersion:    runtime.Version(),
+		BuildVersion: buildVersion,
+		Labels:       copyLabels(cfg.instanceLabels),
+		Incarnation:  incarnation}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	labelsCopy := make(map[string]string, len(labels))
+	for curKey, curValue := range labels {
+		labelsCopy[curKey] = curValue
+	}
+	return labelsCopy
+}
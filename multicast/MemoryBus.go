@@ -0,0 +1,109 @@
+package multicast
+
+import (
+	"net"
+	"sync"
+
+	"github.com/ZacharyDuve/apireg/internal/netutil"
+)
+
+const memoryTransportBufferSize int = 1024
+
+// MemoryBus is an in-process stand in for a multicast group. Every Transport joined to it receives everything any of
+// them sends, including the sender, so many registries can talk to each other inside one test without a network
+type MemoryBus struct {
+	members      []*memoryTransport
+	membersMutex *sync.RWMutex
+	//Last address handed out to a member joining without one
+	lastIP net.IP
+}
+
+func NewMemoryBus() *MemoryBus {
+	b := &MemoryBus{}
+	b.members = make([]*memoryTransport, 0)
+	b.membersMutex = &sync.RWMutex{}
+	b.lastIP = net.IPv4(127, 0, 1, 0).To4()
+	return b
+}
+
+// Join adds a Transport to the bus that sends from addr. A nil addr gets the next free one in 127.0.1.0/24 and up so
+// every member looks like a different host to the others
+func (this *MemoryBus) Join(addr *net.UDPAddr) Transport {
+	this.membersMutex.Lock()
+	defer this.membersMutex.Unlock()
+
+	if addr == nil {
+		this.lastIP = netutil.NextIP(this.lastIP)
+		addr = &net.UDPAddr{IP: this.lastIP, Port: DEFAULT_MULTICAST_GROUP_PORT}
+	}
+	t := &memoryTransport{bus: this, addr: addr}
+	t.inbox = make(chan datagram, memoryTransportBufferSize)
+	t.closed = make(chan struct{})
+	this.members = append(this.members, t)
+	return t
+}
+
+func (this *MemoryBus) deliver(d datagram) {
+	this.membersMutex.RLock()
+	defer this.membersMutex.RUnlock()
+	for _, curMember := range this.members {
+		select {
+		case curMember.inbox <- d:
+		default:
+			//Same as UDP, a receiver that can't keep up loses datagrams rather than slowing everyone down
+		}
+	}
+}
+
+func (this *MemoryBus) leave(t *memoryTransport) {
+	this.membersMutex.Lock()
+	defer this.membersMutex.Unlock()
+	for i, curMember := range this.members {
+		if curMember == t {
+			this.members = append(this.members[:i], this.members[i+1:]...)
+			return
+		}
+	}
+}
+
+type memoryTransport struct {
+	bus       *MemoryBus
+	addr      *net.UDPAddr
+	inbox     chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (this *memoryTransport) Send(data []byte) error {
+	select {
+	case <-this.closed:
+		return net.ErrClosed
+	default:
+	}
+	//Copy so the sender is free to reuse data as soon as we return
+	d := datagram{data: make([]byte, len(data)), from: this.addr}
+	copy(d.data, data)
+	this.bus.deliver(d)
+	return nil
+}
+
+func (this *memoryTransport) Receive(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case d := <-this.inbox:
+		return copy(buf, d.data), d.from, nil
+	case <-this.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (this *memoryTransport) IsLocal(addr *net.UDPAddr) bool {
+	return addr != nil && addr.IP.Equal(this.addr.IP) && addr.Port == this.addr.Port
+}
+
+func (this *memoryTransport) Close() error {
+	this.closeOnce.Do(func() {
+		this.bus.leave(this)
+		close(this.closed)
+	})
+	return nil
+}
@@ -0,0 +1,76 @@
+package multicast
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestThatEveryMemberOfABusGetsWhatIsSent(t *testing.T) {
+	bus := NewMemoryBus()
+	t0 := bus.Join(nil)
+	t1 := bus.Join(nil)
+	defer t0.Close()
+	defer t1.Close()
+
+	failOnErr(t0.Send([]byte("hello")), t)
+
+	for _, curT := range []Transport{t0, t1} {
+		buf := make([]byte, 16)
+		n, from, err := curT.Receive(buf)
+		if err != nil || string(buf[:n]) != "hello" || from == nil {
+			t.Fail()
+		}
+	}
+}
+
+func TestThatBusMembersGetTheirOwnAddresses(t *testing.T) {
+	bus := NewMemoryBus()
+	given := &net.UDPAddr{IP: net.ParseIP("10.0.0.7"), Port: 5324}
+	t0 := bus.Join(nil)
+	t1 := bus.Join(nil)
+	t2 := bus.Join(given)
+
+	t0.Send([]byte("0"))
+	t1.Send([]byte("1"))
+	t2.Send([]byte("2"))
+	buf := make([]byte, 1)
+	_, from0, _ := t2.Receive(buf)
+	_, from1, _ := t2.Receive(buf)
+	_, from2, _ := t2.Receive(buf)
+
+	if from0.IP.Equal(from1.IP) || !from2.IP.Equal(given.IP) {
+		t.Fail()
+	}
+}
+
+func TestThatReceiveTruncatesToTheBuffer(t *testing.T) {
+	bus := NewMemoryBus()
+	t0 := bus.Join(nil)
+	t0.Send([]byte("too long"))
+
+	buf := make([]byte, 3)
+	n, _, _ := t0.Receive(buf)
+	if n != 3 || string(buf) != "too" {
+		t.Fail()
+	}
+}
+
+func TestThatClosedMemberStopsReceivingAndSending(t *testing.T) {
+	bus := NewMemoryBus()
+	t0 := bus.Join(nil)
+	t1 := bus.Join(nil)
+	t1.Close()
+	t1.Close()
+
+	if _, _, err := t1.Receive(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fail()
+	}
+	if err := t1.Send([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fail()
+	}
+	t0.Send([]byte("x"))
+	if len(bus.members) != 1 {
+		t.Fail()
+	}
+}
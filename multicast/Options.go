@@ -0,0 +1,317 @@
+package multicast
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	defaultMulticastTTL int  = 1
+	defaultLoopback     bool = true
+	//Largest payload that fits in a single UDP datagram
+	maxMessageSizeBytes int = 65507
+)
+
+// Option changes how a multicast registry is set up. Pass any number of them to NewMulticastRegistry
+type Option func(*config) error
+
+type config struct {
+	updateInterval   time.Duration
+	lifeSpan         time.Duration
+	messageSizeBytes int
+	multicastTTL     int
+	loopback         bool
+	ifaces           []*net.Interface
+	ipv6             bool
+	//Advertised to peers instead of the packet source address when set
+	advertisedIP       net.IP
+	advertisedHostname string
+	listenerBufferSize int
+	overflowPolicy     OverflowPolicy
+	logger             *log.Logger
+	//Replaces UDP multicast when set
+	transport Transport
+	clock     Clock
+	//Sent to peers along with our apis so they know what we are
+	buildVersion   string
+	instanceLabels map[string]string
+	incarnation    uint64
+	//Told about other registries using our instance ID, they are logged without it
+	duplicateInstanceHandler func(error)
+	//How long NewMulticastRegistry waits for a registry with our instance ID to answer, 0 doesn't check
+	duplicateCheckWait time.Duration
+}
+
+func newDefaultConfig() *config {
+	return &config{
+		updateInterval:     registrationUpdateInterval,
+		lifeSpan:           registrationLifeSpan,
+		messageSizeBytes:   registrationMessageSizeBytes,
+		multicastTTL:       defaultMulticastTTL,
+		loopback:           defaultLoopback,
+		listenerBufferSize: defaultListenerBufferSize,
+		overflowPolicy:     OverflowBlock,
+		logger:             log.Default(),
+		clock:              systemClock{},
+	}
+}
+
+func (this *config) validate() error {
+	if this.updateInterval <= 0 {
+		return errors.New("update interval must be > 0")
+	} else if this.lifeSpan <= this.updateInterval {
+		return errors.New("life span must be greater than the update interval otherwise registrations expire between updates")
+	} else if this.messageSizeBytes <= 0 || this.messageSizeBytes > maxMessageSizeBytes {
+		return errors.New("message size must be > 0 and <= 65507 bytes")
+	} else if this.multicastTTL < 0 || this.multicastTTL > 255 {
+		return errors.New("multicast TTL must be between 0 and 255")
+	} else if this.listenerBufferSize <= 0 {
+		return errors.New("listener buffer size must be > 0")
+	} else if this.overflowPolicy < OverflowBlock || this.overflowPolicy > OverflowCoalesce {
+		return errors.New("unknown listener overflow policy")
+	} else if this.logger == nil {
+		return errors.New("logger is required")
+	} else if this.duplicateCheckWait < 0 {
+		return errors.New("duplicate instance check wait must be >= 0")
+	}
+	return nil
+}
+
+// WithUpdateInterval sets how often our registrations are resent to peers
+func WithUpdateInterval(d time.Duration) Option {
+	return func(c *config) error {
+		c.updateInterval = d
+		return nil
+	}
+}
+
+// WithLifeSpan sets how long a registration from a peer is kept without hearing an update for it
+func WithLifeSpan(d time.Duration) Option {
+	return func(c *config) error {
+		c.lifeSpan = d
+		return nil
+	}
+}
+
+// WithMessageSize sets the max size in bytes of a registration message, both sent and received
+func WithMessageSize(nBytes int) Option {
+	return func(c *config) error {
+		c.messageSizeBytes = nBytes
+		return nil
+	}
+}
+
+// WithMulticastTTL sets how many router hops our registrations can travel. Default is 1 which keeps them on the local network
+func WithMulticastTTL(ttl int) Option {
+	return func(c *config) error {
+		c.multicastTTL = ttl
+		return nil
+	}
+}
+
+// WithMulticastLoopback sets if registrations we send are delivered back to this host. Needed for more than one registry on the same host
+func WithMulticastLoopback(loopback bool) Option {
+	return func(c *config) error {
+		c.loopback = loopback
+		return nil
+	}
+}
+
+// WithInterface adds a network interface to join the multicast group and send registrations on. Default lets the OS pick
+func WithInterface(iface *net.Interface) Option {
+	return WithInterfaces(iface)
+}
+
+// WithInterfaces adds several network interfaces to join the multicast group and send registrations on
+func WithInterfaces(ifaces ...*net.Interface) Option {
+	return func(c *config) error {
+		for _, curIface := range ifaces {
+			if curIface == nil {
+				return errors.New("interface is required for WithInterfaces")
+			} else if curIface.Flags&net.FlagMulticast == 0 {
+				return errors.New(fmt.Sprint("interface ", curIface.Name, " does not support multicast"))
+			}
+			c.ifaces = append(c.ifaces, curIface)
+		}
+		return nil
+	}
+}
+
+// WithInterfaceNames is the same as WithInterfaces but looks the interfaces up by name, ex "eth0"
+func WithInterfaceNames(names ...string) Option {
+	return func(c *config) error {
+		ifaces := make([]*net.Interface, len(names))
+		for i, curName := range names {
+			iface, err := net.InterfaceByName(curName)
+			if err != nil {
+				return err
+			}
+			ifaces[i] = iface
+		}
+		return WithInterfaces(ifaces...)(c)
+	}
+}
+
+// WithIPv6 uses the IPv6 link-local group DEFAULT_MULTICAST_GROUP_IPV6 when no address is given to NewMulticastRegistry.
+// Without any interfaces given every interface that is up and supports multicast is joined
+func WithIPv6() Option {
+	return func(c *config) error {
+		c.ipv6 = true
+		return nil
+	}
+}
+
+// WithAdvertisedIP sets the address peers should dial for all of our apis instead of the address our packets come from.
+// Useful behind NAT, in containers or with a virtual IP. A single registration can still override it with apireg.WithHostIP
+func WithAdvertisedIP(ip net.IP) Option {
+	return func(c *config) error {
+		if ip == nil {
+			return errors.New("ip is required for WithAdvertisedIP")
+		}
+		c.advertisedIP = ip
+		return nil
+	}
+}
+
+// WithAdvertisedHostname sets the hostname sent along with all of our apis
+func WithAdvertisedHostname(hostname string) Option {
+	return func(c *config) error {
+		c.advertisedHostname = hostname
+		return nil
+	}
+}
+
+// WithListenerBufferSize sets how many events a listener can fall behind before its overflow policy kicks in
+func WithListenerBufferSize(size int) Option {
+	return func(c *config) error {
+		c.listenerBufferSize = size
+		return nil
+	}
+}
+
+// WithListenerOverflowPolicy sets what happens to events for a listener that has fallen too far behind. Default is OverflowBlock
+func WithListenerOverflowPolicy(p OverflowPolicy) Option {
+	return func(c *config) error {
+		c.overflowPolicy = p
+		return nil
+	}
+}
+
+// WithLogger sets where the registry logs errors it can't return to the caller
+func WithLogger(l *log.Logger) Option {
+	return func(c *config) error {
+		c.logger = l
+		return nil
+	}
+}
+
+// WithTransport sends and receives registrations over t instead of UDP multicast, t is closed along with the registry.
+// The multicast address, interface, IPv6, TTL and loopback settings don't apply to it
+func WithTransport(t Transport) Option {
+	return func(c *config) error {
+		if t == nil {
+			return errors.New("transport is required for WithTransport")
+		}
+		c.transport = t
+		return nil
+	}
+}
+
+// WithClock sets where the registry gets the time from for expiry and resending, ex a FakeClock in tests
+func WithClock(clock Clock) Option {
+	return func(c *config) error {
+		if clock == nil {
+			return errors.New("clock is required for WithClock")
+		}
+		c.clock = clock
+		return nil
+	}
+}
+
+// WithBuildVersion sets the build version peers see in Instances(). Default is the main module version from the build info
+func WithBuildVersion(version string) Option {
+	return func(c *config) error {
+		c.buildVersion = version
+		return nil
+	}
+}
+
+// WithInstanceLabels sets free form key/value labels about this instance that peers see in Instances(), ex rack=shed-2
+func WithInstanceLabels(labels map[string]string) Option {
+	return func(c *config) error {
+		c.instanceLabels = copyLabels(labels)
+		return nil
+	}
+}
+
+// WithIncarnation sets the number peers use to tell a restart of this instance from leftovers of the last run. It has to
+// be higher every time the instance starts, default is the process start time which already is. Only needed if you
+// keep your own restart counter
+func WithIncarnation(incarnation uint64) Option {
+	return func(c *config) error {
+		if incarnation == 0 {
+			return errors.New("incarnation must be > 0")
+		}
+		c.incarnation = incarnation
+		return nil
+	}
+}
+
+// WithDuplicateInstanceHandler sets what is called with a *DuplicateInstanceError when we hear from another registry
+// using our instance ID, once for each one. It is called from the goroutine reading messages so it shouldn't block.
+// Default is to log it
+func WithDuplicateInstanceHandler(handler func(error)) Option {
+	return func(c *config) error {
+		if handler == nil {
+			return errors.New("handler is required for WithDuplicateInstanceHandler")
+		}
+		c.duplicateInstanceHandler = handler
+		return nil
+	}
+}
+
+// WithDuplicateInstanceCheck makes NewMulticastRegistry ask if any other registry is already using our instance ID and
+// wait up to wait for an answer. If one answers it returns an error that is ErrDuplicateInstance instead of starting.
+// Best effort as the question or answer can be lost like any other datagram
+func WithDuplicateInstanceCheck(wait time.Duration) Option {
+	return func(c *config) error {
+		c.duplicateCheckWait = wait
+		return nil
+	}
+}
+
+func (this *config) network() string {
+	if this.ipv6 {
+		return "udp6"
+	}
+	return "udp"
+}
+
+func (this *config) interfacesToUse() ([]*net.Interface, error) {
+	if len(this.ifaces) > 0 {
+		return this.ifaces, nil
+	}
+	//A nil interface means let the OS pick which is fine for IPv4
+	if !this.ipv6 {
+		return []*net.Interface{nil}, nil
+	}
+	//IPv6 link-local groups are per link so we have to pick them ourselves
+	allIfaces, err := net.Interfaces()
+
+	if err != nil {
+		return nil, err
+	}
+	ifaces := make([]*net.Interface, 0, len(allIfaces))
+	for i := range allIfaces {
+		if allIfaces[i].Flags&net.FlagUp != 0 && allIfaces[i].Flags&net.FlagMulticast != 0 {
+			ifaces = append(ifaces, &allIfaces[i])
+		}
+	}
+	if len(ifaces) == 0 {
+		return nil, errors.New("no interfaces are up with multicast support for IPv6")
+	}
+	return ifaces, nil
+}
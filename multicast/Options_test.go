@@ -0,0 +1,187 @@
+package multicast
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+func TestThatDefaultConfigIsValid(t *testing.T) {
+	if newDefaultConfig().validate() != nil {
+		t.Fail()
+	}
+}
+
+func TestThatLifeSpanNotGreaterThanUpdateIntervalIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithUpdateInterval(time.Second * 10)(c)
+	WithLifeSpan(time.Second * 10)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatZeroUpdateIntervalIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithUpdateInterval(0)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatMessageSizeLargerThanUDPAllowsIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithMessageSize(maxMessageSizeBytes + 1)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatMulticastTTLOutOfRangeIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithMulticastTTL(256)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatNilLoggerIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithLogger(nil)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithInterfaceReturnsErrorForNilInterface(t *testing.T) {
+	if WithInterface(nil)(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithInterfacesReturnsErrorForInterfaceWithoutMulticast(t *testing.T) {
+	if WithInterfaces(&net.Interface{Name: "nomulticast"})(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithInterfaceNamesReturnsErrorForUnknownInterface(t *testing.T) {
+	if WithInterfaceNames("not-a-real-interface0")(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithInterfacesAddsEachInterface(t *testing.T) {
+	c := newDefaultConfig()
+	i0 := &net.Interface{Index: 1, Name: "a0", Flags: net.FlagMulticast}
+	i1 := &net.Interface{Index: 2, Name: "a1", Flags: net.FlagMulticast}
+	WithInterface(i0)(c)
+	WithInterfaces(i1)(c)
+
+	if len(c.ifaces) != 2 {
+		t.Fail()
+	}
+}
+
+func TestThatNewMulticastRegistryReturnsErrorForInvalidOptions(t *testing.T) {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithUpdateInterval(time.Minute), WithLifeSpan(time.Second))
+
+	if err == nil || r != nil {
+		t.Fail()
+	}
+}
+
+func TestThatNewMulticastRegistryAcceptsValidOptions(t *testing.T) {
+	r, err := NewMulticastRegistry(nil, apireg.All, uuid.New(), WithUpdateInterval(time.Second*2), WithLifeSpan(time.Second*8), WithMulticastTTL(2))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+}
+
+func TestThatIPv6ConfigUsesUDP6(t *testing.T) {
+	c := newDefaultConfig()
+	WithIPv6()(c)
+
+	if c.network() != "udp6" {
+		t.Fail()
+	}
+}
+
+func TestThatSendAddrForLinkLocalIPv6GroupHasZone(t *testing.T) {
+	lAddr := &net.UDPAddr{IP: net.ParseIP(DEFAULT_MULTICAST_GROUP_IPV6), Port: DEFAULT_MULTICAST_GROUP_PORT}
+	iface := &net.Interface{Index: 2, Name: "eth0", Flags: net.FlagMulticast}
+
+	if sendAddrForInterface(lAddr, iface).Zone != "eth0" {
+		t.Fail()
+	}
+}
+
+func TestThatSendAddrForIPv4GroupHasNoZone(t *testing.T) {
+	lAddr := &net.UDPAddr{IP: net.ParseIP(DEFAULT_MULTICAST_GROUP_IP), Port: DEFAULT_MULTICAST_GROUP_PORT}
+	iface := &net.Interface{Index: 2, Name: "eth0", Flags: net.FlagMulticast}
+
+	if sendAddrForInterface(lAddr, iface).Zone != "" {
+		t.Fail()
+	}
+}
+
+func TestThatUnknownOverflowPolicyIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithListenerOverflowPolicy(OverflowPolicy(42))(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatZeroListenerBufferSizeIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithListenerBufferSize(0)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithTransportRequiresATransport(t *testing.T) {
+	if WithTransport(nil)(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithClockRequiresAClock(t *testing.T) {
+	if WithClock(nil)(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithIncarnationRequiresMoreThanZero(t *testing.T) {
+	if WithIncarnation(0)(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatWithDuplicateInstanceHandlerRequiresAHandler(t *testing.T) {
+	if WithDuplicateInstanceHandler(nil)(newDefaultConfig()) == nil {
+		t.Fail()
+	}
+}
+
+func TestThatNegativeDuplicateInstanceCheckIsInvalid(t *testing.T) {
+	c := newDefaultConfig()
+	WithDuplicateInstanceCheck(-time.Second)(c)
+
+	if c.validate() == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,55 @@
+package multicast
+
+import (
+	"net"
+	"syscall"
+)
+
+// multicastSendControl sets the send options on a socket before it is connected so the route is picked with them.
+// iface can be nil to leave the sending interface up to the OS
+func multicastSendControl(c *config, iface *net.Interface) func(network, address string, rawConn syscall.RawConn) error {
+	loop := 0
+	if c.loopback {
+		loop = 1
+	}
+
+	return func(network, address string, rawConn syscall.RawConn) error {
+		var sockErr error
+		err := rawConn.Control(func(fd uintptr) {
+			if c.ipv6 {
+				sockErr = setIPv6MulticastOptions(int(fd), c.multicastTTL, loop, iface)
+			} else {
+				sockErr = setIPv4MulticastOptions(int(fd), c.multicastTTL, loop, iface)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+func setIPv4MulticastOptions(fd, ttl, loop int, iface *net.Interface) error {
+	err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	if err != nil {
+		return err
+	}
+	err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, loop)
+	if err != nil || iface == nil {
+		return err
+	}
+	return syscall.SetsockoptIPMreqn(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &syscall.IPMreqn{Ifindex: int32(iface.Index)})
+}
+
+func setIPv6MulticastOptions(fd, hops, loop int, iface *net.Interface) error {
+	err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, hops)
+	if err != nil {
+		return err
+	}
+	err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, loop)
+	if err != nil || iface == nil {
+		return err
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index)
+}
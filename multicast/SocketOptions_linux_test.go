@@ -0,0 +1,50 @@
+package multicast
+
+import (
+	"net"
+	"testing"
+)
+
+func TestThatEachSendConnSendsFromItsInterfacesAddress(t *testing.T) {
+	allIfaces, err := net.Interfaces()
+	failOnErr(err, t)
+	ifaces := make([]*net.Interface, 0, len(allIfaces))
+	for i := range allIfaces {
+		if allIfaces[i].Flags&net.FlagUp != 0 && len(ipv4Addrs(&allIfaces[i])) > 0 {
+			ifaces = append(ifaces, &allIfaces[i])
+		}
+	}
+	//Loopback is enough for one that isn't on the default route
+	if len(ifaces) < 2 {
+		t.Skip("needs at least two interfaces with an IPv4 address")
+	}
+	cfg := newDefaultConfig()
+	lAddr := &net.UDPAddr{IP: net.ParseIP(DEFAULT_MULTICAST_GROUP_IP), Port: DEFAULT_MULTICAST_GROUP_PORT}
+
+	mConns, sendConns, err := openConns(lAddr, cfg, ifaces)
+	failOnErr(err, t)
+	defer closeConns(mConns)
+	defer closeConns(sendConns)
+
+	for i, curConn := range sendConns {
+		localIP := curConn.LocalAddr().(*net.UDPAddr).IP
+		found := false
+		for _, curIP := range ipv4Addrs(ifaces[i]) {
+			found = found || curIP.Equal(localIP)
+		}
+		if !found {
+			t.Error("send conn for ", ifaces[i].Name, " is sending from ", localIP)
+		}
+	}
+}
+
+func ipv4Addrs(iface *net.Interface) []net.IP {
+	addrs, _ := iface.Addrs()
+	ips := make([]net.IP, 0, len(addrs))
+	for _, curAddr := range addrs {
+		if ipNet, ok := curAddr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
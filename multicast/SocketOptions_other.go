@@ -0,0 +1,20 @@
+//go:build !linux
+
+package multicast
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func multicastSendControl(c *config, iface *net.Interface) func(network, address string, rawConn syscall.RawConn) error {
+	return func(network, address string, rawConn syscall.RawConn) error {
+		//Without x/net we can only set these on linux, so only complain if someone asked for something other than the OS defaults.
+		//IPv6 picks its interface from the zone on the send address so that one still works
+		if c.multicastTTL != defaultMulticastTTL || c.loopback != defaultLoopback || (iface != nil && !c.ipv6) {
+			return errors.New("multicast TTL, loopback and interface options are only supported on linux")
+		}
+		return nil
+	}
+}
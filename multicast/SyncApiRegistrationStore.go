@@ -5,170 +5,300 @@ import (
 	"time"
 
 	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
 )
 
 type syncApiRegStore struct {
-	regs          map[string][]*apiRegistration
-	regsMutex     *sync.RWMutex
-	purgeTickChan <-chan time.Time
-	listeners     *syncRegListenStore
+	regs      map[string][]*apiRegistration
+	regsMutex *sync.RWMutex
+	//Every registration in regs by when it expires, guarded by regsMutex
+	expiries *expiryQueue
+	//Tells the expiry loop the soonest deadline changed
+	expiriesChanged chan struct{}
+	listeners       *syncRegListenStore
+	clock           Clock
+	//Goes up by one for every event sent to listeners, guarded by regsMutex
+	revision  uint64
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func newSyncApiRegistrationStore(pChan <-chan time.Time) *syncApiRegStore {
+func newSyncApiRegistrationStore() *syncApiRegStore {
+	return newSyncApiRegistrationStoreWithListeners(newSyncRegistrationListenerStore(), systemClock{})
+}
+
+func newSyncApiRegistrationStoreWithListeners(listeners *syncRegListenStore, clock Clock) *syncApiRegStore {
 	syncStore := &syncApiRegStore{}
 	syncStore.regs = make(map[string][]*apiRegistration)
 	syncStore.regsMutex = &sync.RWMutex{}
-	syncStore.listeners = newSyncRegistrationListenerStore()
-	//if we never provide a channel then auto purging is disabled
-	if pChan != nil {
-		syncStore.purgeTickChan = pChan
-		go syncStore.purgeLoop()
-	}
+	syncStore.expiries = newExpiryQueue()
+	syncStore.expiriesChanged = make(chan struct{}, 1)
+	syncStore.listeners = listeners
+	syncStore.clock = clock
+	syncStore.done = make(chan struct{})
+	go syncStore.expiryLoop()
 
 	return syncStore
 }
 
 func (this *syncApiRegStore) AddReg(reg *apiRegistration) {
 	this.regsMutex.Lock()
-	apis, contains := this.regs[reg.Api().Name()]
-	added := false
+	this.addLocked(reg)
+	this.regsMutex.Unlock()
+	this.listeners.WaitForListeners()
+}
 
-	if !contains {
-		apis = make([]*apiRegistration, 1)
-		apis[0] = reg
-		this.regs[reg.Api().Name()] = apis
-		added = true
-	} else {
-		hasMatch := false
-		for _, curReg := range apis {
-			if apisMatch(reg.Api(), curReg.Api()) {
-				hasMatch = true
-				break
-			}
+// addLocked must be called with regsMutex held for writing. Does nothing if an api matching reg's is already there
+func (this *syncApiRegStore) addLocked(reg *apiRegistration) {
+	apis := this.regs[reg.Api().Name()]
+	for _, curReg := range apis {
+		if apisMatch(reg.Api(), curReg.Api()) {
+			return
 		}
+	}
+	this.regs[reg.Api().Name()] = append(apis, reg)
+	this.scheduleLocked(reg)
+	this.notifyLocked(apireg.NewAddEvent(reg.Api()))
+}
 
-		if !hasMatch {
-			apis = append(apis, reg)
-			this.regs[reg.Api().Name()] = apis
-			added = true
+// PutApi refreshes the registration matching a, or adds one if there isn't any, as heard at t. instance is what the
+// publisher said about itself along with a. A restart on the same address replaces the old registration with a single
+// Updated event, anything still arriving from before the restart is ignored
+func (this *syncApiRegStore) PutApi(a apireg.Api, instance apireg.InstanceInfo, t time.Time, lifeSpan time.Duration) {
+	this.regsMutex.Lock()
+	this.putLocked(a, instance, t, lifeSpan)
+	this.regsMutex.Unlock()
+	this.listeners.WaitForListeners()
+}
+
+// putLocked must be called with regsMutex held for writing
+func (this *syncApiRegStore) putLocked(a apireg.Api, instance apireg.InstanceInfo, t time.Time, lifeSpan time.Duration) {
+	for _, curReg := range this.regs[a.Name()] {
+		//Includes ones past their deadline the expiry loop hasn't got to yet, they never got an Expired event so just carry on
+		if apisMatch(curReg.Api(), a) {
+			if isStaleIncarnation(curReg, a.UUID(), instance.Incarnation) {
+				return
+			}
+			this.updateLocked(curReg, a, instance, t, lifeSpan)
+			return
 		}
 	}
-	if added {
-		this.listeners.Notify(apireg.NewAddEvent(reg.Api()))
+	reg, err := newApiRegistration(a, t, lifeSpan)
+	if err == nil {
+		reg.UpdateInstance(instance)
+		this.addLocked(reg)
 	}
-	this.regsMutex.Unlock()
+}
+
+// notifyLocked must be called with regsMutex held for writing so revisions and event order line up. Whoever took the
+// lock calls listeners.WaitForListeners after letting it go
+func (this *syncApiRegStore) notifyLocked(e apireg.RegistrationEvent) {
+	this.revision++
+	this.listeners.Notify(apireg.EventWithRevision(e, this.revision))
+}
+
+// Subscribe takes a snapshot of every registration and adds a listener without any change getting in between.
+// newListener is given the snapshot revision so it can skip events from before it that are still being delivered
+func (this *syncApiRegStore) Subscribe(newListener func(revision uint64) apireg.RegistrationListener) ([]*apiRegistration, uint64) {
+	this.regsMutex.RLock()
+	defer this.regsMutex.RUnlock()
 
+	regs := make([]*apiRegistration, 0)
+	for _, curRegs := range this.regs {
+		regs = append(regs, curRegs...)
+	}
+	this.listeners.Add(newListener(this.revision))
+	return regs, this.revision
 }
 
 func apisMatch(api0, api1 apireg.Api) bool {
 	return api0.Name() == api1.Name() &&
 		api0.Version().Equal(api1.Version()) &&
 		api0.HostIP().Equal(api1.HostIP()) &&
+		api0.HostZone() == api1.HostZone() &&
 		api0.HostPort() == api1.HostPort()
 }
 
 func (this *syncApiRegStore) GetAllRegsForName(name string) []*apiRegistration {
-	return this.getAllRegsForNameAndTime(name, time.Now())
+	return this.getAllRegsForNameAndTime(name, this.clock.Now())
 }
 
-func (this *syncApiRegStore) getAllRegsForNameAndTime(name string, time time.Time) []*apiRegistration {
-	var matchingApis []*apiRegistration
+func (this *syncApiRegStore) getAllRegsForNameAndTime(name string, t time.Time) []*apiRegistration {
 	this.regsMutex.RLock()
-	regs, contains := this.regs[name]
-	this.regsMutex.RUnlock()
-
-	if contains {
-		matchingApis = make([]*apiRegistration, 0, len(regs))
-
-		for _, curReg := range regs {
-			if curReg.Expired(time) {
-				this.RemoveRegForApi(curReg.Api())
-			} else {
-				matchingApis = append(matchingApis, curReg)
-			}
-		}
-	}
-
-	return matchingApis
+	defer this.regsMutex.RUnlock()
+	return this.appendUnexpiredLocked(nil, this.regs[name], t)
 }
 
 func (this *syncApiRegStore) GetAllRegs() []*apiRegistration {
-	return this.getAllRegsForTime(time.Now())
+	return this.getAllRegsForTime(this.clock.Now())
 }
 
 func (this *syncApiRegStore) getAllRegsForTime(t time.Time) []*apiRegistration {
-	//Pulling list of names first from regs so we can release lock from Read mode as GetAllRegs could request lock for Write mode for an expired record
 	this.regsMutex.RLock()
-	regNames := make([]string, 0, len(this.regs))
-	for curName := range this.regs {
-		regNames = append(regNames, curName)
-	}
-	this.regsMutex.RUnlock()
-
+	defer this.regsMutex.RUnlock()
 	regs := make([]*apiRegistration, 0)
-	for _, curName := range regNames {
-		regs = append(regs, this.getAllRegsForNameAndTime(curName, t)...)
+	for _, curRegs := range this.regs {
+		regs = this.appendUnexpiredLocked(regs, curRegs, t)
 	}
 	return regs
 }
 
-func (this *syncApiRegStore) RemoveRegForApi(old apireg.Api) error {
+// appendUnexpiredLocked must be called with regsMutex held. Expired ones are left for the expiry loop so reading never changes anything
+func (this *syncApiRegStore) appendUnexpiredLocked(dst, regs []*apiRegistration, t time.Time) []*apiRegistration {
+	if dst == nil {
+		dst = make([]*apiRegistration, 0, len(regs))
+	}
+	for _, curReg := range regs {
+		if !curReg.Expired(t) {
+			dst = append(dst, curReg)
+		}
+	}
+	return dst
+}
+
+// isStaleIncarnation is true when a message from instance id with incarnation is from before the one reg was last heard
+// from, ex a resend from before a restart that arrived late. Incarnations are only compared within one instance, from
+// the one a restart replaced anything up to the incarnation it was on is a leftover. 0 is from senders that don't send
+// one so is never stale
+func isStaleIncarnation(reg *apiRegistration, id uuid.UUID, incarnation uint64) bool {
+	if incarnation == 0 {
+		return false
+	}
+	if reg.Api().UUID() == id {
+		current := reg.Instance().Incarnation
+		return current != 0 && incarnation < current
+	}
+	replacedID, replacedIncarnation := reg.Replaced()
+	return replacedID == id && incarnation <= replacedIncarnation
+}
+
+// RemoveRegForApi is for apis that said goodbye, listeners get a Deregistered event. Only the instance holding the
+// address can say goodbye for it and only from its current incarnation, anything else is from a process it replaced
+func (this *syncApiRegStore) RemoveRegForApi(old apireg.Api, incarnation uint64) error {
 	this.regsMutex.Lock()
-	apis, contains := this.regs[old.Name()]
+	for _, curReg := range this.regs[old.Name()] {
+		if apisMatch(old, curReg.Api()) && (curReg.Api().UUID() != old.UUID() || isStaleIncarnation(curReg, old.UUID(), incarnation)) {
+			this.regsMutex.Unlock()
+			return nil
+		}
+	}
+	//Only tell listeners when something was actually removed, unregister messages can be for apis we never saw
+	if removed := this.removeLocked(old); removed != nil {
+		this.notifyLocked(apireg.NewDeregisteredEvent(removed.Api()))
+	}
+	this.regsMutex.Unlock()
+	this.listeners.WaitForListeners()
+	return nil
+}
 
-	if contains {
-		if len(apis) == 1 && apisMatch(old, apis[0].Api()) {
-			delete(this.regs, old.Name())
-		} else {
-			for i, curReg := range apis {
-				if apisMatch(old, curReg.Api()) {
-					apis = append(apis[:i], apis[i+1:]...)
-					this.regs[old.Name()] = apis
-					break
-				}
+// removeLocked must be called with regsMutex held for writing. Returns the removed registration or nil if there wasn't one
+func (this *syncApiRegStore) removeLocked(old apireg.Api) *apiRegistration {
+	apis, contains := this.regs[old.Name()]
+	if !contains {
+		return nil
+	}
+	for i, curReg := range apis {
+		if apisMatch(old, curReg.Api()) {
+			if len(apis) == 1 {
+				delete(this.regs, old.Name())
+			} else {
+				this.regs[old.Name()] = append(apis[:i], apis[i+1:]...)
 			}
+			this.expiries.Cancel(curReg)
+			return curReg
 		}
-		rEvent := apireg.NewRemovedEvent(old)
-		this.listeners.Notify(rEvent)
 	}
-	this.regsMutex.Unlock()
 	return nil
 }
 
-func (this *syncApiRegStore) purgeLoop() {
-	for t := range this.purgeTickChan {
-		this.purgeExpired(t)
+// updateLocked must be called with regsMutex held for writing. Listeners get an Updated event with the previous api when
+// anything other than the time it was heard from changed. A new incarnation is a restart so counts as a change even if
+// the api looks the same
+func (this *syncApiRegStore) updateLocked(reg *apiRegistration, a apireg.Api, instance apireg.InstanceInfo, t time.Time, lifeSpan time.Duration) {
+	prev := reg.Api()
+	changed := !prev.Equal(a) || reg.LifeSpan() != lifeSpan || reg.Instance().Incarnation != instance.Incarnation
+	if prev.UUID() != a.UUID() {
+		reg.UpdateReplaced(prev.UUID(), reg.Instance().Incarnation)
+	}
+	reg.UpdateApi(a)
+	reg.UpdateInstance(instance)
+	reg.Refresh(t, lifeSpan)
+	if this.containsLocked(reg) {
+		this.scheduleLocked(reg)
+		if changed {
+			this.notifyLocked(apireg.NewUpdatedEvent(a, prev))
+		}
 	}
 }
 
-func (this *syncApiRegStore) purgeExpired(t time.Time) {
-	//Pulling list of names first from regs so we can release lock from Read mode as GetAllRegs could request lock for Write mode for an expired record
-	this.regsMutex.RLock()
-	regNames := make([]string, 0, len(this.regs))
-	for curName := range this.regs {
-		regNames = append(regNames, curName)
+func (this *syncApiRegStore) containsLocked(reg *apiRegistration) bool {
+	for _, curReg := range this.regs[reg.Api().Name()] {
+		if curReg == reg {
+			return true
+		}
 	}
-	this.regsMutex.RUnlock()
+	return false
+}
 
-	for _, curName := range regNames {
-		this.purgeExpiredForNameAndTime(curName, t)
+// scheduleLocked must be called with regsMutex held for writing whenever reg is added or its deadline changes
+func (this *syncApiRegStore) scheduleLocked(reg *apiRegistration) {
+	if this.expiries.Schedule(reg, reg.Deadline()) {
+		select {
+		case this.expiriesChanged <- struct{}{}:
+		default:
+			//Loop already has a wake up waiting
+		}
 	}
 }
 
-func (this *syncApiRegStore) purgeExpiredForNameAndTime(name string, t time.Time) {
-	this.regsMutex.RLock()
-	regs, contains := this.regs[name]
-	this.regsMutex.RUnlock()
+// expiryLoop removes each registration as soon as its deadline passes rather than waiting for someone to look
+func (this *syncApiRegStore) expiryLoop() {
+	for {
+		this.regsMutex.Lock()
+		now := this.clock.Now()
+		this.expireLocked(now)
+		next, scheduled := this.expiries.Next()
+		this.regsMutex.Unlock()
+		this.listeners.WaitForListeners()
 
-	if contains {
-		for _, curReg := range regs {
-			if curReg.Expired(t) {
-				this.RemoveRegForApi(curReg.Api())
+		var timer Timer
+		var timerChan <-chan time.Time
+		if scheduled {
+			timer = this.clock.NewTimer(next.Sub(now))
+			timerChan = timer.C()
+		}
+		select {
+		case <-timerChan:
+		case <-this.expiriesChanged:
+		case <-this.done:
+			if timer != nil {
+				timer.Stop()
 			}
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// expireLocked must be called with regsMutex held for writing. Removes every registration due by t and tells listeners they Expired
+func (this *syncApiRegStore) expireLocked(t time.Time) {
+	for _, curReg := range this.expiries.PopDue(t) {
+		if this.removeLocked(curReg.Api()) != nil {
+			this.notifyLocked(apireg.NewExpiredEvent(curReg.Api()))
 		}
 	}
 }
 
+func (this *syncApiRegStore) Close() {
+	//Stops the expiry loop and listener delivery, safe to call more than once
+	this.closeOnce.Do(func() {
+		close(this.done)
+		this.listeners.Close()
+	})
+}
+
 func (this *syncApiRegStore) AddListener(l apireg.RegistrationListener) {
 	this.listeners.Add(l)
 }
@@ -9,22 +9,14 @@ import (
 	"github.com/google/uuid"
 )
 
-func TestNewSyncApiRegistrationStoreWithTickerReturnsStore(t *testing.T) {
-	ticker := get30sTicker()
-
-	if newSyncApiRegistrationStore(ticker) == nil {
-		t.Fail()
-	}
-}
-
-func TestThatNewSyncApiRegistrationStoreWithNoTickerReturnsStore(t *testing.T) {
-	if newSyncApiRegistrationStore(nil) == nil {
+func TestThatNewSyncApiRegistrationStoreReturnsStore(t *testing.T) {
+	if newSyncApiRegistrationStore() == nil {
 		t.Fail()
 	}
 }
 
 func TestThatGetAllRegsReturnsEmptyListForNewSyncStore(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	allRegs := store.GetAllRegs()
 	if allRegs == nil || len(allRegs) != 0 {
 		t.Fail()
@@ -32,7 +24,7 @@ func TestThatGetAllRegsReturnsEmptyListForNewSyncStore(t *testing.T) {
 }
 
 func TestThatGetAllReturnsListOfLen1AfterAddingNewRegistration(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 
 	store.AddReg(getValidApiReg())
 	allRegs := store.GetAllRegs()
@@ -42,7 +34,7 @@ func TestThatGetAllReturnsListOfLen1AfterAddingNewRegistration(t *testing.T) {
 }
 
 func TestThatAddingTheSameRegAgainDoesntAddAnotherRegistration(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 
 	reg := getValidApiReg()
 
@@ -56,7 +48,7 @@ func TestThatAddingTheSameRegAgainDoesntAddAnotherRegistration(t *testing.T) {
 }
 
 func TestThatAddingAtLeastTwoUniqueRegsAddsAsMany(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	reg0 := getValidApiRegWithNameAndVersion("Steve", apireg.NewVersion(1, 0, 0))
 	store.AddReg(reg0)
 	reg1 := getValidApiRegWithNameAndVersion("Bob", apireg.NewVersion(1, 0, 0))
@@ -69,7 +61,7 @@ func TestThatAddingAtLeastTwoUniqueRegsAddsAsMany(t *testing.T) {
 }
 
 func TestThatAddingAtLeastTwoUniqueRegsWithSameNameAddsAsMany(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	name := "Jerry"
 	var majVersion uint = 6
 	reg0 := getValidApiRegWithNameAndVersion(name, apireg.NewVersion(majVersion, 0, 0))
@@ -84,13 +76,13 @@ func TestThatAddingAtLeastTwoUniqueRegsWithSameNameAddsAsMany(t *testing.T) {
 }
 
 func TestThatRemovingFromEmptyRegistrationStoreDoesNothing(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 
 	reg := getValidApiReg()
 
 	sizeBefore := len(store.GetAllRegs())
 
-	store.RemoveRegForApi(reg.Api())
+	store.RemoveRegForApi(reg.Api(), 0)
 
 	if sizeBefore != len(store.GetAllRegs()) {
 		t.Fail()
@@ -98,13 +90,13 @@ func TestThatRemovingFromEmptyRegistrationStoreDoesNothing(t *testing.T) {
 }
 
 func TestThatRemovingAnApiWithStoreContainingSameNameButDifferentVersionDoesNotRemoveExisting(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	name := "Jerry"
 	var majVersion uint = 6
 	reg0 := getValidApiRegWithNameAndVersion(name, apireg.NewVersion(majVersion, 0, 0))
 	store.AddReg(reg0)
 	reg1 := getValidApiRegWithNameAndVersion(name, apireg.NewVersion(majVersion+1, 0, 0))
-	store.RemoveRegForApi(reg1.Api())
+	store.RemoveRegForApi(reg1.Api(), 0)
 	allRegs := store.GetAllRegs()
 	if len(allRegs) != 1 {
 		t.Fail()
@@ -112,25 +104,25 @@ func TestThatRemovingAnApiWithStoreContainingSameNameButDifferentVersionDoesNotR
 }
 
 func TestThatRemovingAnApiFromStoreContainingItActuallyRemoves(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	reg := getValidApiReg()
 	store.AddReg(reg)
 	sizeBefore := len(store.GetAllRegs())
-	store.RemoveRegForApi(reg.Api())
+	store.RemoveRegForApi(reg.Api(), 0)
 	if sizeBefore-1 != len(store.GetAllRegs()) {
 		t.Fail()
 	}
 }
 
 func TestThatStoreContainingMultipleRegsForSameNameOnlyRemovesOneWhileKeepingRest(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 	name := "Jerry"
 	var majVersion uint = 6
 	reg0 := getValidApiRegWithNameAndVersion(name, apireg.NewVersion(majVersion, 0, 0))
 	store.AddReg(reg0)
 	reg1 := getValidApiRegWithNameAndVersion(name, apireg.NewVersion(majVersion+1, 0, 0))
 	store.AddReg(reg1)
-	store.RemoveRegForApi(reg1.Api())
+	store.RemoveRegForApi(reg1.Api(), 0)
 	allRegs := store.GetAllRegsForName(name)
 	if len(allRegs) != 1 {
 		t.Fail()
@@ -138,7 +130,7 @@ func TestThatStoreContainingMultipleRegsForSameNameOnlyRemovesOneWhileKeepingRes
 }
 
 func TestThatGetAllForNameFiltersOutExpiredRegistrations(t *testing.T) {
-	store := newSyncApiRegistrationStore(nil)
+	store := newSyncApiRegistrationStore()
 
 	name := "Jerry"
 	api, _ := apireg.NewApi(name, apireg.NewVersion(0, 0, 1), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 8672)
@@ -155,25 +147,234 @@ func TestThatGetAllForNameFiltersOutExpiredRegistrations(t *testing.T) {
 	}
 }
 
-func TestThatExpiredRegsArePurgedWhenPurgeExpiredIsCalled(t *testing.T) {
+func TestThatExpiredRegsAreRemovedByTheExpiryLoop(t *testing.T) {
 	now := time.Now()
 	life := time.Second * 2
 	//Make so it has expired already
 	regTime := now.Add(-1 * (life + time.Second*1))
 	api := getValidApi()
 	reg, _ := newApiRegistration(api, regTime, life)
-	purgeTickChan := make(chan time.Time)
-	store := newSyncApiRegistrationStore(purgeTickChan)
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+
+	store.AddReg(reg)
 
+	if !waitForRegCount(store, 0) {
+		t.Fail()
+	}
+}
+
+func TestThatReadsDoNotRemoveExpiredRegs(t *testing.T) {
+	life := time.Second * 2
+	reg, _ := newApiRegistration(getValidApi(), time.Now(), life)
+	store := newSyncApiRegistrationStore()
 	store.AddReg(reg)
+	//Stop the expiry loop so only the read could remove it
+	store.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	later := time.Now().Add(life * 2)
+	if len(store.getAllRegsForNameAndTime(reg.Api().Name(), later)) != 0 || len(store.getAllRegsForTime(later)) != 0 {
+		t.Fail()
+	}
+	store.regsMutex.RLock()
+	defer store.regsMutex.RUnlock()
+	if len(store.regs[reg.Api().Name()]) != 1 {
+		t.Fail()
+	}
+}
+
+func TestThatRegExpiresExactlyAtItsDeadline(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	store := newSyncApiRegistrationStoreWithListeners(newSyncRegistrationListenerStore(), clock)
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	life := time.Minute
+	reg, _ := newApiRegistration(getValidApi(), clock.Now(), life)
+	store.AddReg(reg)
+	waitForTimerAt(clock, reg.Deadline())
+
+	clock.Advance(life - time.Nanosecond)
+	if len(store.GetAllRegs()) != 1 {
+		t.Fatal("expired early")
+	}
+	clock.Advance(time.Nanosecond)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[1].Type() != apireg.Expired || len(store.GetAllRegs()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatRefreshingARegPushesBackItsExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	store := newSyncApiRegistrationStoreWithListeners(newSyncRegistrationListenerStore(), clock)
+	defer store.Close()
+	life := time.Minute
+	reg, _ := newApiRegistration(getValidApi(), clock.Now(), life)
+	store.AddReg(reg)
+	waitForTimerAt(clock, reg.Deadline())
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(life / 2)
+		store.PutApi(reg.Api(), apireg.InstanceInfo{}, clock.Now(), life)
+		waitForTimerAt(clock, reg.Deadline())
+	}
+	if len(store.GetAllRegs()) != 1 {
+		t.Fail()
+	}
+	clock.Advance(life)
+	if !waitForRegCount(store, 0) {
+		t.Fail()
+	}
+}
+
+func TestThatPutApiAddsThenUpdates(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	a := getValidApi()
+
+	store.PutApi(a, apireg.InstanceInfo{}, time.Now(), time.Minute)
+	store.PutApi(a, apireg.InstanceInfo{}, time.Now(), time.Minute)
+	store.PutApi(a, apireg.InstanceInfo{}, time.Now(), time.Minute*2)
+
+	events := l.waitForEvents(2)
+	if len(store.GetAllRegs()) != 1 || len(events) != 2 || events[0].Type() != apireg.Added || events[1].Type() != apireg.Updated {
+		t.Fail()
+	}
+}
+
+func TestThatARestartReplacesTheOldRegistrationWithOneUpdated(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	old := getValidApi()
+	restarted, _ := apireg.NewApi(old.Name(), old.Version(), uuid.New(), old.Environment(), old.HostIP(), old.HostPort())
+
+	store.PutApi(old, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
+	store.PutApi(restarted, apireg.InstanceInfo{Incarnation: 2}, time.Now(), time.Minute)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[1].Type() != apireg.Updated || events[1].Api().UUID() != restarted.UUID() || events[1].Previous().UUID() != old.UUID() {
+		t.Fatal("expected Added then a single Updated for the restart", events)
+	}
+	regs := store.GetAllRegs()
+	if len(regs) != 1 || regs[0].Api().UUID() != restarted.UUID() {
+		t.Fail()
+	}
+}
+
+func TestThatARestartWithTheSameUUIDSendsUpdated(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	a := getValidApi()
+
+	store.PutApi(a, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
+	store.PutApi(a, apireg.InstanceInfo{Incarnation: 2}, time.Now(), time.Minute)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[1].Type() != apireg.Updated {
+		t.Fail()
+	}
+}
+
+func TestThatMessagesFromBeforeARestartAreIgnored(t *testing.T) {
+	old := getValidApi()
+	newUUID, _ := apireg.NewApi(old.Name(), old.Version(), uuid.New(), old.Environment(), old.HostIP(), old.HostPort())
+	for _, restarted := range []apireg.Api{old, newUUID} {
+		store := newSyncApiRegistrationStore()
+		l := &recordingListener{}
+		store.AddListener(l)
+		store.PutApi(old, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
+		store.PutApi(restarted, apireg.InstanceInfo{Incarnation: 2}, time.Now(), time.Minute)
+
+		//A resend and then the goodbye from the old process turn up late
+		store.PutApi(old, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
+		store.RemoveRegForApi(old, 1)
+
+		regs := store.GetAllRegs()
+		if len(regs) != 1 || regs[0].Api().UUID() != restarted.UUID() || regs[0].Instance().Incarnation != 2 {
+			t.Fatal("old incarnation replaced the new one")
+		}
+		//Goodbye from the current incarnation still works
+		store.RemoveRegForApi(restarted, 2)
+		events := l.waitForEvents(3)
+		if len(events) != 3 || events[1].Type() != apireg.Updated || events[2].Type() != apireg.Deregistered {
+			t.Error("expected Added, Updated for the restart then Deregistered", events)
+		}
+		store.Close()
+	}
+}
+
+func TestThatTheReplacedInstanceCanComeBackAfterItRestarts(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	first := getValidApi()
+	second, _ := apireg.NewApi(first.Name(), first.Version(), uuid.New(), first.Environment(), first.HostIP(), first.HostPort())
+	store.PutApi(first, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
+	store.PutApi(second, apireg.InstanceInfo{Incarnation: 2}, time.Now(), time.Minute)
+
+	store.PutApi(first, apireg.InstanceInfo{Incarnation: 3}, time.Now(), time.Minute)
+
+	regs := store.GetAllRegs()
+	if len(regs) != 1 || regs[0].Api().UUID() != first.UUID() {
+		t.Fail()
+	}
+}
+
+func TestThatIncarnationsOfDifferentInstancesAreNotCompared(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	first := getValidApi()
+	//Took over the address with a counter that happens to be lower
+	second, _ := apireg.NewApi(first.Name(), first.Version(), uuid.New(), first.Environment(), first.HostIP(), first.HostPort())
+	store.PutApi(first, apireg.InstanceInfo{Incarnation: 5}, time.Now(), time.Minute)
 
-	purgeTickChan <- now
+	store.PutApi(second, apireg.InstanceInfo{Incarnation: 1}, time.Now(), time.Minute)
 
+	regs := store.GetAllRegs()
+	if len(regs) != 1 || regs[0].Api().UUID() != second.UUID() {
+		t.Fatal("expected the other instance to replace the first")
+	}
+	store.RemoveRegForApi(second, 1)
 	if len(store.GetAllRegs()) != 0 {
 		t.Fail()
 	}
 }
 
+func TestThatSendersWithoutAnIncarnationAreNeverStale(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	a := getValidApi()
+	store.PutApi(a, apireg.InstanceInfo{Incarnation: 2}, time.Now(), time.Minute)
+
+	store.RemoveRegForApi(a, 0)
+
+	if len(store.GetAllRegs()) != 0 {
+		t.Fail()
+	}
+}
+
+func waitForRegCount(store *syncApiRegStore, n int) bool {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		store.regsMutex.RLock()
+		count := store.expiries.Len()
+		store.regsMutex.RUnlock()
+		if count == n {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
 func getValidApiReg() *apiRegistration {
 	reg, _ := newApiRegistration(getValidApi(), time.Now(), time.Second*15)
 
@@ -190,6 +391,87 @@ func getValidApiRegWithNameAndVersion(name string, version apireg.Version) *apiR
 	return retReg
 }
 
-func get30sTicker() <-chan time.Time {
-	return time.NewTicker(time.Second * 30).C
+func TestThatCloseStopsTheExpiryLoop(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	store.Close()
+	life := time.Millisecond * 10
+	reg, _ := newApiRegistration(getValidApi(), time.Now(), life)
+	store.AddReg(reg)
+	time.Sleep(life * 5)
+
+	if store.expiries.Len() != 1 {
+		t.Fail()
+	}
+}
+
+func TestThatRemovingARegSendsDeregistered(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	reg := getValidApiReg()
+	store.AddReg(reg)
+	store.RemoveRegForApi(reg.Api(), 0)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[1].Type() != apireg.Deregistered || !events[1].Type().IsRemoval() {
+		t.Fail()
+	}
+}
+
+func TestThatExpiringARegSendsExpired(t *testing.T) {
+	life := time.Second * 2
+	reg, _ := newApiRegistration(getValidApi(), time.Now().Add(-1*(life+time.Second)), life)
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	store.AddReg(reg)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[1].Type() != apireg.Expired {
+		t.Fail()
+	}
+}
+
+func TestThatPutApiSendsUpdatedWithPreviousApiOnlyWhenSomethingChanged(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	reg := getValidApiReg()
+	prev := reg.Api()
+	store.AddReg(reg)
+
+	//Just a heartbeat so nothing to tell anyone
+	store.PutApi(prev, reg.Instance(), time.Now(), reg.LifeSpan())
+	changed, _ := apireg.NewApi(prev.Name(), prev.Version(), prev.UUID(), prev.Environment(), prev.HostIP(), prev.HostPort(), apireg.WithProtocol(apireg.HTTP))
+	store.PutApi(changed, reg.Instance(), time.Now(), reg.LifeSpan())
+	store.PutApi(changed, reg.Instance(), time.Now(), reg.LifeSpan()*2)
+
+	events := l.waitForEvents(3)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	if events[1].Type() != apireg.Updated || events[1].Api().Protocol() != apireg.HTTP || events[1].Previous().Protocol() != apireg.UnknownProtocol {
+		t.Fail()
+	}
+	if events[2].Type() != apireg.Updated || reg.LifeSpan() != time.Second*30 {
+		t.Fail()
+	}
+}
+
+// waitForTimerAt waits for a timer on clock due at deadline. Advancing before the expiry loop has set its timer
+// would start the timer from the advanced time instead
+func waitForTimerAt(clock *FakeClock, deadline time.Time) bool {
+	return waitFor(func() bool {
+		clock.mutex.Lock()
+		defer clock.mutex.Unlock()
+		for _, curW := range clock.waiters {
+			if curW.period == 0 && curW.deadline.Equal(deadline) {
+				return true
+			}
+		}
+		return false
+	})
 }
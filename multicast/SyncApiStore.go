@@ -7,28 +7,54 @@ import (
 )
 
 type syncApiStore struct {
-	apis      []apireg.Api
+	apis []apireg.Api
+	//One of every api that has ever been in apis, guarded by apisMutex
+	everAdded []apireg.Api
 	apisMutex *sync.RWMutex
 }
 
 func newSyncApiStore() *syncApiStore {
 	s := &syncApiStore{}
 	s.apis = make([]apireg.Api, 0)
+	s.everAdded = make([]apireg.Api, 0)
 	s.apisMutex = &sync.RWMutex{}
 
 	return s
 }
 
-func (this *syncApiStore) Add(newApi apireg.Api) bool {
-	var added bool
-	contains := this.Contains(newApi)
+// Add stores newApi in place of any api with the same name, version, address and port, so registering again with
+// different metadata or protocol changes the registration instead of adding a second one. Returns the api it replaced
+// or nil if there wasn't one
+func (this *syncApiStore) Add(newApi apireg.Api) apireg.Api {
+	this.apisMutex.Lock()
+	defer this.apisMutex.Unlock()
+	if !containsMatch(this.everAdded, newApi) {
+		this.everAdded = append(this.everAdded, newApi)
+	}
+	for i, curApi := range this.apis {
+		if apisMatch(curApi, newApi) {
+			this.apis[i] = newApi
+			return curApi
+		}
+	}
+	this.apis = append(this.apis, newApi)
+	return nil
+}
 
-	if !contains {
-		this.apisMutex.Lock()
-		this.apis = append(this.apis, newApi)
-		this.apisMutex.Unlock()
+// WasAdded is true if an api with the same name, version, address and port is or ever was in the store
+func (this *syncApiStore) WasAdded(a apireg.Api) bool {
+	this.apisMutex.RLock()
+	defer this.apisMutex.RUnlock()
+	return containsMatch(this.everAdded, a)
+}
+
+func containsMatch(apis []apireg.Api, a apireg.Api) bool {
+	for _, curApi := range apis {
+		if apisMatch(curApi, a) {
+			return true
+		}
 	}
-	return added
+	return false
 }
 
 func (this *syncApiStore) All() []apireg.Api {
@@ -37,9 +63,10 @@ func (this *syncApiStore) All() []apireg.Api {
 	copy(apisCopy, this.apis)
 	this.apisMutex.RUnlock()
 
-	return this.apis
+	return apisCopy
 }
 
+// Contains is true if exactly a is stored, everything about it the same
 func (this *syncApiStore) Contains(a apireg.Api) bool {
 	var contains bool
 	this.apisMutex.RLock()
@@ -63,3 +63,17 @@ func TestThatRemovingAnApiFromAStoreThatContainsItRemovesIt(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestThatAddingTheSameApiWithOtherMetadataReplacesIt(t *testing.T) {
+	s := newSyncApiStore()
+	id := uuid.New()
+	a0, _ := apireg.NewApi("Something", apireg.NewVersion(0, 0, 1), id, apireg.All, net.ParseIP("127.0.0.1"), 8712, apireg.WithMetadata(map[string]string{"build": "abc"}))
+	a1, _ := apireg.NewApi("Something", apireg.NewVersion(0, 0, 1), id, apireg.All, net.ParseIP("127.0.0.1"), 8712, apireg.WithMetadata(map[string]string{"build": "def"}))
+
+	if s.Add(a0) != nil || s.Add(a1) != a0 {
+		t.Fail()
+	}
+	if all := s.All(); len(all) != 1 || all[0] != a1 {
+		t.Fail()
+	}
+}
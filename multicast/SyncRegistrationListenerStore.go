@@ -1,45 +1,221 @@
 package multicast
 
 import (
+	"fmt"
+	"log"
+	"runtime/debug"
 	"sync"
 
 	"github.com/ZacharyDuve/apireg"
 )
 
+// OverflowPolicy decides what happens when a listener has fallen behind by more than the listener buffer size
+type OverflowPolicy int
+
+const (
+	//The registry waits for the listener to catch up before its next change. Other listeners still get every event
+	//as it happens and the registry can still be read, also from inside a listener
+	OverflowBlock OverflowPolicy = iota
+	//Throw away the oldest event the listener hasn't seen yet
+	OverflowDropOldest
+	//Replace an older event for the same api with the new one, or drop the oldest if there isn't one
+	OverflowCoalesce
+)
+
+const defaultListenerBufferSize int = 256
+
 type syncRegListenStore struct {
-	listeners      []apireg.RegistrationListener
+	listeners      []*listenerQueue
 	listenersMutex *sync.RWMutex
+	bufferSize     int
+	policy         OverflowPolicy
+	logger         *log.Logger
+	closed         bool
 }
 
 func newSyncRegistrationListenerStore() *syncRegListenStore {
+	return newSyncRegistrationListenerStoreWithPolicy(defaultListenerBufferSize, OverflowBlock, log.Default())
+}
+
+func newSyncRegistrationListenerStoreWithPolicy(bufferSize int, policy OverflowPolicy, logger *log.Logger) *syncRegListenStore {
 	s := &syncRegListenStore{}
-	s.listeners = make([]apireg.RegistrationListener, 0)
+	s.listeners = make([]*listenerQueue, 0)
 	s.listenersMutex = &sync.RWMutex{}
-
+	s.bufferSize = bufferSize
+	s.policy = policy
+	s.logger = logger
 	return s
 }
 
 func (this *syncRegListenStore) Add(l apireg.RegistrationListener) {
+	q := newListenerQueue(l, this.bufferSize, this.policy, this.logger)
 	this.listenersMutex.Lock()
-	this.listeners = append(this.listeners, l)
+	if this.closed {
+		q.Close()
+	} else {
+		this.listeners = append(this.listeners, q)
+	}
 	this.listenersMutex.Unlock()
 }
 func (this *syncRegListenStore) Remove(l apireg.RegistrationListener) {
 	this.listenersMutex.Lock()
-	for i, curL := range this.listeners {
-		if curL == l {
+	for i, curQ := range this.listeners {
+		if curQ.listener == l {
 			this.listeners = append(this.listeners[:i], this.listeners[i+1:]...)
+			curQ.Close()
 			break
 		}
 	}
 	this.listenersMutex.Unlock()
 }
+
+// Notify hands e straight to each listener's own queue and never blocks, so it is safe to call while holding the
+// registration lock, which is also what keeps events in order. OverflowBlock listeners can go over their buffer by
+// the events of one change until WaitForListeners
 func (this *syncRegListenStore) Notify(e apireg.RegistrationEvent) {
-	go func() {
-		this.listenersMutex.RLock()
-		for _, curL := range this.listeners {
-			curL.HandleRegistration(e)
+	this.listenersMutex.RLock()
+	for _, curQ := range this.listeners {
+		curQ.Enqueue(e)
+	}
+	this.listenersMutex.RUnlock()
+}
+
+// WaitForListeners blocks while any OverflowBlock listener is over its buffer. Call it after letting go of the
+// registration lock so a listener that reads the registry can still catch up
+func (this *syncRegListenStore) WaitForListeners() {
+	//Copy so a blocked listener doesn't hold the lock that Add and Remove need
+	this.listenersMutex.RLock()
+	listeners := make([]*listenerQueue, len(this.listeners))
+	copy(listeners, this.listeners)
+	this.listenersMutex.RUnlock()
+
+	for _, curQ := range listeners {
+		curQ.WaitForRoom()
+	}
+}
+
+func (this *syncRegListenStore) Close() {
+	this.listenersMutex.Lock()
+	this.closed = true
+	for _, curQ := range this.listeners {
+		curQ.Close()
+	}
+	this.listeners = nil
+	this.listenersMutex.Unlock()
+}
+
+// listenerQueue delivers events to a single listener in order on its own goroutine. Its buffer is only for it so a
+// slow listener never delays events to the others
+type listenerQueue struct {
+	listener apireg.RegistrationListener
+	capacity int
+	policy   OverflowPolicy
+	logger   *log.Logger
+	events   []apireg.RegistrationEvent
+	mutex    *sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	closed   bool
+}
+
+func newListenerQueue(l apireg.RegistrationListener, capacity int, policy OverflowPolicy, logger *log.Logger) *listenerQueue {
+	q := &listenerQueue{listener: l, capacity: capacity, policy: policy, logger: logger}
+	q.events = make([]apireg.RegistrationEvent, 0, capacity)
+	q.mutex = &sync.Mutex{}
+	q.notEmpty = sync.NewCond(q.mutex)
+	q.notFull = sync.NewCond(q.mutex)
+
+	go q.deliverLoop()
+	return q
+}
+
+// Enqueue never blocks. With OverflowBlock the queue goes over capacity until WaitForRoom holds up whoever is sending
+func (this *listenerQueue) Enqueue(e apireg.RegistrationEvent) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.events) >= this.capacity {
+		switch this.policy {
+		case OverflowBlock:
+			//Goes over, WaitForRoom holds up the sender instead
+		case OverflowCoalesce:
+			if !this.removeQueuedForSameApi(e) {
+				this.dropOldest()
+			}
+		default:
+			this.dropOldest()
+		}
+	}
+	if this.closed {
+		return
+	}
+	this.events = append(this.events, e)
+	this.notEmpty.Signal()
+}
+
+// WaitForRoom waits until the queue is no longer over capacity, only OverflowBlock queues ever are
+func (this *listenerQueue) WaitForRoom() {
+	this.mutex.Lock()
+	for len(this.events) > this.capacity && !this.closed {
+		this.notFull.Wait()
+	}
+	this.mutex.Unlock()
+}
+
+func (this *listenerQueue) dropOldest() {
+	this.events[0] = nil
+	this.events = this.events[1:]
+}
+
+func (this *listenerQueue) removeQueuedForSameApi(e apireg.RegistrationEvent) bool {
+	if e == nil || e.Api() == nil {
+		return false
+	}
+	for i, curE := range this.events {
+		if curE != nil && curE.Api() != nil && apisMatch(curE.Api(), e.Api()) {
+			this.events = append(this.events[:i], this.events[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (this *listenerQueue) deliverLoop() {
+	for {
+		this.mutex.Lock()
+		for len(this.events) == 0 && !this.closed {
+			this.notEmpty.Wait()
+		}
+		if this.closed {
+			this.mutex.Unlock()
+			return
+		}
+		e := this.events[0]
+		this.events[0] = nil
+		this.events = this.events[1:]
+		//Everyone sending waits on it
+		this.notFull.Broadcast()
+		this.mutex.Unlock()
+
+		this.deliver(e)
+	}
+}
+
+func (this *listenerQueue) deliver(e apireg.RegistrationEvent) {
+	//One bad listener shouldn't take down the registry or stop later events from reaching it
+	defer func() {
+		if r := recover(); r != nil {
+			this.logger.Println(fmt.Sprint("Registration listener panicked: ", r, "\n", string(debug.Stack())))
 		}
-		this.listenersMutex.RUnlock()
 	}()
+	this.listener.HandleRegistration(e)
+}
+
+func (this *listenerQueue) Close() {
+	this.mutex.Lock()
+	this.closed = true
+	this.events = nil
+	this.notEmpty.Broadcast()
+	this.notFull.Broadcast()
+	this.mutex.Unlock()
 }
@@ -0,0 +1,272 @@
+package multicast
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+type recordingListener struct {
+	mutex  sync.Mutex
+	events []apireg.RegistrationEvent
+	//Closed to let HandleRegistration return, nil means don't wait
+	release chan struct{}
+}
+
+func (this *recordingListener) HandleRegistration(e apireg.RegistrationEvent) {
+	if this.release != nil {
+		<-this.release
+	}
+	this.mutex.Lock()
+	this.events = append(this.events, e)
+	this.mutex.Unlock()
+}
+
+func (this *recordingListener) Events() []apireg.RegistrationEvent {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	eventsCopy := make([]apireg.RegistrationEvent, len(this.events))
+	copy(eventsCopy, this.events)
+	return eventsCopy
+}
+
+func (this *recordingListener) waitForEvents(n int) []apireg.RegistrationEvent {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		if events := this.Events(); len(events) >= n {
+			return events
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return this.Events()
+}
+
+type panickingListener struct{}
+
+func (this *panickingListener) HandleRegistration(e apireg.RegistrationEvent) {
+	panic("listener blew up")
+}
+
+func TestThatEventsAreDeliveredInOrderUnderLoad(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+
+	//Adding and removing the same api over and over means any reordering shows up as two of the same type in a row
+	reg := getValidApiReg()
+	n := 2000
+	for i := 0; i < n/2; i++ {
+		store.AddReg(reg)
+		store.RemoveRegForApi(reg.Api(), 0)
+	}
+
+	events := l.waitForEvents(n)
+	if len(events) != n {
+		t.Fatalf("got %d events, want %d", len(events), n)
+	}
+	for i, curE := range events {
+		wantType := apireg.Added
+		if i%2 == 1 {
+			wantType = apireg.Deregistered
+		}
+		if curE.Type() != wantType {
+			t.Fatalf("event %d was %s, want %s", i, curE.Type(), wantType)
+		}
+	}
+}
+
+func TestThatAPanickingListenerDoesNotStopOtherEventsOrListeners(t *testing.T) {
+	s := newSyncRegistrationListenerStoreWithPolicy(8, OverflowBlock, log.New(io.Discard, "", 0))
+	defer s.Close()
+	s.Add(&panickingListener{})
+	l := &recordingListener{}
+	s.Add(l)
+
+	s.Notify(apireg.NewAddEvent(getValidApi()))
+	s.Notify(apireg.NewAddEvent(getValidApi()))
+
+	if len(l.waitForEvents(2)) != 2 {
+		t.Fail()
+	}
+}
+
+func TestThatDropOldestLosesTheOldestQueuedEvent(t *testing.T) {
+	a0, _ := apireg.NewApi("Zero", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 80)
+	a1, _ := apireg.NewApi("One", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 81)
+	l := &recordingListener{release: make(chan struct{})}
+	q := newListenerQueue(l, 2, OverflowDropOldest, log.Default())
+	defer q.Close()
+	//The first event gets picked up and blocks in the listener so the next ones stay queued
+	blocker, _ := apireg.NewApi("Blocker", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 82)
+	q.Enqueue(apireg.NewAddEvent(blocker))
+	waitForQueueLen(q, 0)
+
+	q.Enqueue(apireg.NewAddEvent(a0))
+	q.Enqueue(apireg.NewAddEvent(a1))
+	q.Enqueue(apireg.NewDeregisteredEvent(a1))
+	close(l.release)
+
+	events := l.waitForEvents(3)[1:]
+	if len(events) != 2 || events[0].Api().Name() != "One" || events[1].Type() != apireg.Deregistered {
+		t.Fail()
+	}
+}
+
+func TestThatCoalesceReplacesQueuedEventForSameApiEvenWhenNotOldest(t *testing.T) {
+	a0, _ := apireg.NewApi("Zero", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 80)
+	a1, _ := apireg.NewApi("One", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 81)
+	l := &recordingListener{release: make(chan struct{})}
+	q := newListenerQueue(l, 2, OverflowCoalesce, log.Default())
+	defer q.Close()
+	//The first event gets picked up and blocks in the listener so the next ones stay queued
+	blocker, _ := apireg.NewApi("Blocker", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 82)
+	q.Enqueue(apireg.NewAddEvent(blocker))
+	waitForQueueLen(q, 0)
+
+	q.Enqueue(apireg.NewAddEvent(a0))
+	q.Enqueue(apireg.NewAddEvent(a1))
+	//Drop oldest would lose Add(Zero) here, coalesce drops the queued event for One instead
+	q.Enqueue(apireg.NewDeregisteredEvent(a1))
+	close(l.release)
+
+	events := l.waitForEvents(3)[1:]
+	if len(events) != 2 || events[0].Api().Name() != "Zero" || events[1].Type() != apireg.Deregistered {
+		t.Fail()
+	}
+}
+
+func TestThatRemovingABlockedListenerDoesNotHang(t *testing.T) {
+	s := newSyncRegistrationListenerStoreWithPolicy(1, OverflowBlock, log.Default())
+	defer s.Close()
+	l := &recordingListener{release: make(chan struct{})}
+	s.Add(l)
+	for i := 0; i < 5; i++ {
+		s.Notify(apireg.NewAddEvent(getValidApi()))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Remove(l)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fail()
+	}
+	close(l.release)
+}
+
+func TestThatAStuckListenerDoesNotStarveAnother(t *testing.T) {
+	s := newSyncRegistrationListenerStoreWithPolicy(1, OverflowDropOldest, log.Default())
+	defer s.Close()
+	stuck := &recordingListener{release: make(chan struct{})}
+	defer close(stuck.release)
+	s.Add(stuck)
+	fast := &recordingListener{}
+	s.Add(fast)
+
+	for i := 0; i < 100000; i++ {
+		s.Notify(apireg.NewAddEvent(getValidApi()))
+	}
+	last, _ := apireg.NewApi("Last", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 80)
+	s.Notify(apireg.NewAddEvent(last))
+
+	//With a buffer of 1 it can miss some while it keeps up, but it has to get to the end
+	if !waitFor(func() bool {
+		events := fast.Events()
+		return len(events) > 0 && events[len(events)-1].Api().Name() == "Last"
+	}) {
+		t.Fatal("fast listener never caught up, got", len(fast.Events()))
+	}
+	stuckQ := s.listeners[0]
+	stuckQ.mutex.Lock()
+	defer stuckQ.mutex.Unlock()
+	if len(stuckQ.events) > 1 {
+		t.Error("stuck listener's queue grew to", len(stuckQ.events))
+	}
+}
+
+func TestThatABlockingListenerHoldsUpTheRegistryButNotOtherListeners(t *testing.T) {
+	listeners := newSyncRegistrationListenerStoreWithPolicy(1, OverflowBlock, log.Default())
+	store := newSyncApiRegistrationStoreWithListeners(listeners, systemClock{})
+	defer store.Close()
+	stuck := &recordingListener{release: make(chan struct{})}
+	store.AddListener(stuck)
+	fast := &recordingListener{}
+	store.AddListener(fast)
+
+	n := 10
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			store.AddReg(getValidApiRegWithNameAndVersion(fmt.Sprint("Api", i), apireg.NewVersion(1, 0, 0)))
+		}
+		close(added)
+	}()
+
+	//One event in the stuck listener, one queued, one over the buffer, then the registry waits
+	if len(fast.waitForEvents(3)) != 3 {
+		t.Fatal("fast listener didn't get the events sent so far")
+	}
+	time.Sleep(time.Millisecond * 20)
+	if len(fast.Events()) != 3 {
+		t.Error("registry kept going past a full OverflowBlock listener")
+	}
+
+	close(stuck.release)
+	<-added
+	if len(fast.waitForEvents(n)) != n || len(stuck.waitForEvents(n)) != n {
+		t.Fail()
+	}
+}
+
+type readingListener struct {
+	store *syncApiRegStore
+	recordingListener
+}
+
+func (this *readingListener) HandleRegistration(e apireg.RegistrationEvent) {
+	this.store.GetAllRegs()
+	this.recordingListener.HandleRegistration(e)
+}
+
+func TestThatABlockingListenerCanReadTheRegistry(t *testing.T) {
+	listeners := newSyncRegistrationListenerStoreWithPolicy(1, OverflowBlock, log.Default())
+	store := newSyncApiRegistrationStoreWithListeners(listeners, systemClock{})
+	defer store.Close()
+	l := &readingListener{store: store}
+	store.AddListener(l)
+
+	n := 50
+	for i := 0; i < n; i++ {
+		store.AddReg(getValidApiRegWithNameAndVersion(fmt.Sprint("Api", i), apireg.NewVersion(1, 0, 0)))
+	}
+
+	if len(l.waitForEvents(n)) != n {
+		t.Fail()
+	}
+}
+
+func waitForQueueLen(q *listenerQueue, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		q.mutex.Lock()
+		curLen := len(q.events)
+		q.mutex.Unlock()
+		if curLen == n {
+			//Give the deliver loop a moment to get into the listener
+			time.Sleep(time.Millisecond * 10)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,203 @@
+package multicast
+
+import (
+	"net"
+	"sync"
+)
+
+// Transport carries registration messages between registries. By default a registry sends UDP multicast but anything
+// that can broadcast datagrams works, ex NewMemoryBus for running many registries inside one test
+type Transport interface {
+	//Send delivers data to every registry on the transport. It may or may not come back to us as well
+	Send(data []byte) error
+	//Receive blocks until a datagram arrives, copies as much of it as fits into buf and says who sent it.
+	//Returns net.ErrClosed once the transport is closed
+	Receive(buf []byte) (int, *net.UDPAddr, error)
+	Close() error
+}
+
+// LocalTransport is a Transport that can tell datagrams we sent from others by where they come from. A registry uses it
+// to spot another registry with its instance ID, with other transports it goes without that check
+type LocalTransport interface {
+	Transport
+	//IsLocal is true if datagrams we send can arrive from addr
+	IsLocal(addr *net.UDPAddr) bool
+}
+
+type datagram struct {
+	data []byte
+	from *net.UDPAddr
+	err  error
+}
+
+// udpTransport is the default Transport, listening and sending on the multicast group on each interface
+type udpTransport struct {
+	//One listening connection per interface we joined the group on
+	mConns []*net.UDPConn
+	//One sending connection per interface so registrations go out with that interface's address
+	sendConns []*net.UDPConn
+	//Everything read from any of mConns
+	received  chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newUDPTransport(lAddr *net.UDPAddr, cfg *config, ifaces []*net.Interface) (*udpTransport, error) {
+	mConns, sendConns, err := openConns(lAddr, cfg, ifaces)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &udpTransport{mConns: mConns, sendConns: sendConns}
+	t.received = make(chan datagram)
+	t.closed = make(chan struct{})
+	for _, curConn := range mConns {
+		go t.readLoop(curConn, cfg.messageSizeBytes)
+	}
+	return t, nil
+}
+
+func openConns(lAddr *net.UDPAddr, cfg *config, ifaces []*net.Interface) ([]*net.UDPConn, []*net.UDPConn, error) {
+	mConns := make([]*net.UDPConn, 0, len(ifaces))
+	sendConns := make([]*net.UDPConn, 0, len(ifaces))
+	var err error
+
+	for _, curIface := range ifaces {
+		var mC, sC *net.UDPConn
+		mC, err = net.ListenMulticastUDP(cfg.network(), curIface, lAddr)
+		if err != nil {
+			break
+		}
+		mConns = append(mConns, mC)
+
+		sC, err = dialSendConn(sendAddrForInterface(lAddr, curIface), cfg, curIface)
+		if err != nil {
+			break
+		}
+		sendConns = append(sendConns, sC)
+	}
+
+	if err != nil {
+		closeConns(mConns)
+		closeConns(sendConns)
+		return nil, nil, err
+	}
+	return mConns, sendConns, nil
+}
+
+// dialSendConn connects to the group from iface's address with the send options already set. Connecting picks the
+// source address and would otherwise use the interface the default route goes out of, ex eth0's address on wlan0
+func dialSendConn(sendAddr *net.UDPAddr, cfg *config, iface *net.Interface) (*net.UDPConn, error) {
+	dialer := &net.Dialer{Control: multicastSendControl(cfg, iface)}
+	if localAddr := sendLocalAddr(cfg, iface); localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+	conn, err := dialer.Dial(cfg.network(), sendAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// sendLocalAddr is iface's IPv4 address to send from, nil to let the OS pick. IPv6 gets the right link-local source
+// from the zone on the send address
+func sendLocalAddr(cfg *config, iface *net.Interface) *net.UDPAddr {
+	if iface == nil || cfg.ipv6 {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, curAddr := range addrs {
+		if ipNet, ok := curAddr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return &net.UDPAddr{IP: ipNet.IP}
+		}
+	}
+	return nil
+}
+
+func sendAddrForInterface(lAddr *net.UDPAddr, iface *net.Interface) *net.UDPAddr {
+	//Link-local IPv6 groups (ff02::) only make sense with a zone telling which link to send on
+	if iface == nil || lAddr.IP.To4() != nil || !(lAddr.IP.IsLinkLocalMulticast() || lAddr.IP.IsInterfaceLocalMulticast()) {
+		return lAddr
+	}
+	return &net.UDPAddr{IP: lAddr.IP, Port: lAddr.Port, Zone: iface.Name}
+}
+
+func closeConns(conns []*net.UDPConn) error {
+	var firstErr error
+	for _, curConn := range conns {
+		if err := curConn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (this *udpTransport) readLoop(mConn *net.UDPConn, bufferSize int) {
+	readBuff := make([]byte, bufferSize)
+	for {
+		nRead, rAddr, err := mConn.ReadFromUDP(readBuff)
+		d := datagram{from: rAddr, err: err}
+		if err == nil {
+			d.data = make([]byte, nRead)
+			copy(d.data, readBuff[:nRead])
+		}
+		select {
+		case this.received <- d:
+		case <-this.closed:
+			return
+		}
+	}
+}
+
+func (this *udpTransport) Send(data []byte) error {
+	//Send out of every interface, only report the first failure so one bad interface doesn't stop the rest
+	var firstErr error
+	for _, curConn := range this.sendConns {
+		if _, err := curConn.Write(data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (this *udpTransport) Receive(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case d := <-this.received:
+		return copy(buf, d.data), d.from, d.err
+	case <-this.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// IsLocal is true for any address of this host as ours come from whichever interface they went out of
+func (this *udpTransport) IsLocal(addr *net.UDPAddr) bool {
+	if addr == nil || addr.IP.IsLoopback() {
+		return true
+	}
+	//Looked up every time as addresses come and go, ex with DHCP
+	localAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		//Can't tell so don't claim it is someone else
+		return true
+	}
+	for _, curAddr := range localAddrs {
+		if ipNet, ok := curAddr.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *udpTransport) Close() error {
+	var err error
+	this.closeOnce.Do(func() {
+		close(this.closed)
+		closeConns(this.sendConns)
+		err = closeConns(this.mConns)
+	})
+	return err
+}
@@ -0,0 +1,18 @@
+package multicast
+
+import (
+	"net"
+	"testing"
+)
+
+func TestThatUDPTransportKnowsItsOwnAddresses(t *testing.T) {
+	transport := &udpTransport{}
+
+	if !transport.IsLocal(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}) {
+		t.Fail()
+	}
+	//TEST-NET-1 which no host should have
+	if transport.IsLocal(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}) {
+		t.Fail()
+	}
+}
@@ -1,7 +1,20 @@
 package multicast
 
+import "github.com/ZacharyDuve/apireg"
+
 type versionJSON struct {
 	Major  uint `json:"major"`
 	Minor  uint `json:"minor"`
 	BugFix uint `json:"bugfix"`
+	//Left off the wire for plain releases so older registries see the same message as before
+	PreRelease string `json:"pre-release,omitempty"`
+	Build      string `json:"build,omitempty"`
+}
+
+func newVersionJSON(v apireg.Version) *versionJSON {
+	return &versionJSON{Major: v.Major(), Minor: v.Minor(), BugFix: v.BugFix(), PreRelease: v.PreRelease(), Build: v.Build()}
+}
+
+func (this *versionJSON) toVersion() (apireg.Version, error) {
+	return apireg.NewSemanticVersion(this.Major, this.Minor, this.BugFix, this.PreRelease, this.Build)
 }
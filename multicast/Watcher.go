@@ -0,0 +1,92 @@
+package multicast
+
+import (
+	"sync"
+
+	"github.com/ZacharyDuve/apireg"
+)
+
+const watchBufferSize int = 64
+
+// watcher is a RegistrationListener that forwards matching events onto a channel without ever blocking the registry
+type watcher struct {
+	query  apireg.Query
+	events chan apireg.RegistrationEvent
+	//Events at or before this revision are already part of a snapshot the subscriber has
+	afterRevision uint64
+	//Guards closed so we never send on a closed channel. We are the only sender so holding it means there is room after a receive
+	mutex  sync.Mutex
+	closed bool
+}
+
+func newWatcher(q apireg.Query, bufferSize int) *watcher {
+	return &watcher{query: q, events: make(chan apireg.RegistrationEvent, bufferSize)}
+}
+
+func (this *watcher) HandleRegistration(e apireg.RegistrationEvent) {
+	e = this.eventForQuery(e)
+	if e == nil {
+		return
+	}
+	//Store revisions start at 1 so 0 means no snapshot was taken
+	if this.afterRevision > 0 && e.Revision() <= this.afterRevision {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return
+	}
+
+	select {
+	case this.events <- e:
+		return
+	default:
+	}
+	//Full so make room by dropping the oldest unread event. The new event carries the count so the reader
+	//always finds out, even if nothing else ever happens after this
+	var missed uint
+	select {
+	case dropped := <-this.events:
+		missed = 1 + dropped.Missed()
+	default:
+		//Reader emptied it in the meantime
+	}
+	this.events <- apireg.EventWithMissed(e, missed)
+}
+
+// eventForQuery is e as someone who only sees apis matching the query would see it, nil if they wouldn't see it at
+// all. An update can move an api into the query, which is Added, or out of it, which is Removed with the api as it was
+func (this *watcher) eventForQuery(e apireg.RegistrationEvent) apireg.RegistrationEvent {
+	if e == nil {
+		return nil
+	}
+	matches := this.query.Matches(e.Api())
+	if e.Type() != apireg.Updated {
+		if !matches {
+			return nil
+		}
+		return e
+	}
+
+	matched := this.query.Matches(e.Previous())
+	switch {
+	case matches && matched:
+		return e
+	case matches:
+		return apireg.EventWithRevision(apireg.NewAddEvent(e.Api()), e.Revision())
+	case matched:
+		return apireg.EventWithRevision(apireg.NewRemovedEvent(e.Previous()), e.Revision())
+	default:
+		return nil
+	}
+}
+
+func (this *watcher) Close() {
+	this.mutex.Lock()
+	if !this.closed {
+		this.closed = true
+		close(this.events)
+	}
+	this.mutex.Unlock()
+}
@@ -0,0 +1,197 @@
+package multicast
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/apireg"
+	"github.com/google/uuid"
+)
+
+func TestThatWatcherOnlyForwardsMatchingEvents(t *testing.T) {
+	w := newWatcher(apireg.Query{Name: "Wanted"}, 4)
+	wanted := getValidApiRegWithNameAndVersion("Wanted", apireg.NewVersion(1, 0, 0)).Api()
+	other := getValidApiRegWithNameAndVersion("Other", apireg.NewVersion(1, 0, 0)).Api()
+
+	w.HandleRegistration(apireg.NewAddEvent(other))
+	w.HandleRegistration(apireg.NewAddEvent(wanted))
+
+	if len(w.events) != 1 || (<-w.events).Api() != wanted {
+		t.Fail()
+	}
+}
+
+func TestThatWatcherSeesApisMoveInAndOutOfTheQuery(t *testing.T) {
+	w := newWatcher(apireg.Query{Metadata: map[string]string{"zone": "a"}}, 8)
+	id := uuid.New()
+	ip := net.ParseIP("192.168.0.3")
+	inA, _ := apireg.NewApi("Moving", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"zone": "a"}))
+	inB, _ := apireg.NewApi("Moving", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"zone": "b"}))
+	alsoInB, _ := apireg.NewApi("Moving", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"zone": "b", "rack": "2"}))
+
+	w.HandleRegistration(apireg.EventWithRevision(apireg.NewUpdatedEvent(inA, inB), 1))
+	w.HandleRegistration(apireg.EventWithRevision(apireg.NewUpdatedEvent(inB, inA), 2))
+	w.HandleRegistration(apireg.EventWithRevision(apireg.NewUpdatedEvent(alsoInB, inB), 3))
+
+	if len(w.events) != 2 {
+		t.Fatal("expected only the moves in and out, got", len(w.events))
+	}
+	in := <-w.events
+	out := <-w.events
+	if in.Type() != apireg.Added || in.Api() != inA || in.Revision() != 1 {
+		t.Fail()
+	}
+	if out.Type() != apireg.Removed || !out.Type().IsRemoval() || out.Api() != inA || out.Revision() != 2 {
+		t.Fail()
+	}
+}
+
+func TestThatWatchSeesAnApiLeaveItsQueryWhenUpdated(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}, closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := r.Watch(ctx, apireg.Query{Metadata: map[string]string{"zone": "a"}})
+	failOnErr(err, t)
+	id := uuid.New()
+	ip := net.ParseIP("192.168.0.3")
+	inA, _ := apireg.NewApi("Moving", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"zone": "a"}))
+	inB, _ := apireg.NewApi("Moving", apireg.NewVersion(1, 0, 0), id, apireg.All, ip, 80, apireg.WithMetadata(map[string]string{"zone": "b"}))
+
+	r.updateForApi(inA, apireg.InstanceInfo{}, time.Minute)
+	r.updateForApi(inB, apireg.InstanceInfo{}, time.Minute)
+	r.apiRegs.RemoveRegForApi(inB, 0)
+
+	for _, want := range []apireg.EventType{apireg.Added, apireg.Removed} {
+		select {
+		case e := <-events:
+			if e.Type() != want {
+				t.Fatal("got", e.Type(), "want", want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("never got", want)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Error("got an event for an api outside the query", e.Type())
+	case <-time.After(time.Millisecond * 20):
+	}
+}
+
+func TestThatWatcherCountsMissedEventsWhenFull(t *testing.T) {
+	w := newWatcher(apireg.Query{}, 1)
+	a := getValidApi()
+
+	w.HandleRegistration(apireg.NewAddEvent(a))
+	w.HandleRegistration(apireg.NewRemovedEvent(a))
+	w.HandleRegistration(apireg.NewAddEvent(a))
+	//The two oldest were dropped to make room and the newest says so
+	newest := <-w.events
+	w.HandleRegistration(apireg.NewRemovedEvent(a))
+	next := <-w.events
+
+	if newest.Type() != apireg.Added || newest.Missed() != 2 || next.Missed() != 0 {
+		t.Fail()
+	}
+}
+
+func TestThatClosedWatcherIgnoresEvents(t *testing.T) {
+	w := newWatcher(apireg.Query{}, 1)
+	w.Close()
+	w.Close()
+	w.HandleRegistration(apireg.NewAddEvent(getValidApi()))
+
+	if _, open := <-w.events; open {
+		t.Fail()
+	}
+}
+
+func TestThatWatchChannelClosesWhenContextIsCancelled(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}, closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := r.Watch(ctx, apireg.Query{})
+	failOnErr(err, t)
+
+	r.updateForApi(getValidApi(), apireg.InstanceInfo{}, time.Minute)
+	select {
+	case e := <-events:
+		if e.Type() != apireg.Added {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event from watch")
+	}
+
+	cancel()
+	select {
+	case _, open := <-events:
+		if open {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch channel was not closed")
+	}
+}
+
+func TestThatSubscribeSnapshotAndEventsNeitherMissNorDoubleCount(t *testing.T) {
+	r := &multicastApiRegistry{id: uuid.New(), apiRegs: newSyncApiRegistrationStore(), clock: systemClock{}, closed: make(chan struct{})}
+	defer r.apiRegs.Close()
+	n := 500
+
+	go func() {
+		for i := 0; i < n; i++ {
+			a, _ := apireg.NewApi("Racing", apireg.NewVersion(1, 0, 0), uuid.New(), apireg.All, net.ParseIP("192.168.0.3"), 1000+i)
+			r.updateForApi(a, apireg.InstanceInfo{}, time.Minute)
+		}
+	}()
+	//Let some registrations land before subscribing so the snapshot isn't empty
+	time.Sleep(time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshot, events, err := r.Subscribe(ctx, apireg.Query{Name: "Racing"})
+	failOnErr(err, t)
+
+	seen := make(map[int]bool)
+	for _, curApi := range snapshot.Apis {
+		seen[curApi.HostPort()] = true
+	}
+	lastRevision := snapshot.Revision
+	//Watchers drop instead of blocking so a burst this big can overflow, those still count as long as we're told
+	var missed uint
+	timeout := time.After(time.Second * 5)
+	for len(seen)+int(missed) < n {
+		select {
+		case e := <-events:
+			missed += e.Missed()
+			if e.Revision() <= lastRevision {
+				t.Fatalf("event revision %d not after %d", e.Revision(), lastRevision)
+			}
+			lastRevision = e.Revision()
+			if seen[e.Api().HostPort()] {
+				t.Fatalf("port %d was counted twice", e.Api().HostPort())
+			}
+			seen[e.Api().HostPort()] = true
+		case <-timeout:
+			t.Fatalf("only saw %d and missed %d of %d apis", len(seen), missed, n)
+		}
+	}
+}
+
+func TestThatEventsCarryIncreasingRevisions(t *testing.T) {
+	store := newSyncApiRegistrationStore()
+	defer store.Close()
+	l := &recordingListener{}
+	store.AddListener(l)
+	reg := getValidApiReg()
+
+	store.AddReg(reg)
+	store.RemoveRegForApi(reg.Api(), 0)
+
+	events := l.waitForEvents(2)
+	if len(events) != 2 || events[0].Revision() != 1 || events[1].Revision() != 2 {
+		t.Fail()
+	}
+}